@@ -0,0 +1,249 @@
+package mocka
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ArgPredicate tests one argument of a query: a "name = value" argument of
+// the command's where clause or a publish data context value. Entries carry
+// them in Entry.Args (YAML match.args); an entry only matches a query whose
+// arguments satisfy all of its predicates.
+type ArgPredicate struct {
+	op     string // eq, ne, in, regex, glob, gt, ge, lt, le, exists or absent
+	values []string
+	num    float64
+	re     *regexp.Regexp
+	fold   *regexp.Regexp // re, case-insensitive
+}
+
+// ArgEq matches an argument equal to value.
+func ArgEq(value string) ArgPredicate { return ArgPredicate{op: "eq", values: []string{value}} }
+
+// ArgNe matches an argument that is absent or not equal to value.
+func ArgNe(value string) ArgPredicate { return ArgPredicate{op: "ne", values: []string{value}} }
+
+// ArgIn matches an argument equal to any of values.
+func ArgIn(values ...string) ArgPredicate { return ArgPredicate{op: "in", values: values} }
+
+// ArgRegex matches an argument matched by re.
+func ArgRegex(re *regexp.Regexp) ArgPredicate {
+	return ArgPredicate{op: "regex", re: re, fold: regexp.MustCompile("(?i)" + re.String())}
+}
+
+// ArgGlob matches an argument matched by a path.Match pattern such as
+// "SO-*". A malformed pattern matches nothing.
+func ArgGlob(pattern string) ArgPredicate { return ArgPredicate{op: "glob", values: []string{pattern}} }
+
+// ArgGt matches a numeric argument greater than n.
+func ArgGt(n float64) ArgPredicate { return ArgPredicate{op: "gt", num: n} }
+
+// ArgGe matches a numeric argument greater than or equal to n.
+func ArgGe(n float64) ArgPredicate { return ArgPredicate{op: "ge", num: n} }
+
+// ArgLt matches a numeric argument less than n.
+func ArgLt(n float64) ArgPredicate { return ArgPredicate{op: "lt", num: n} }
+
+// ArgLe matches a numeric argument less than or equal to n.
+func ArgLe(n float64) ArgPredicate { return ArgPredicate{op: "le", num: n} }
+
+// ArgExists matches a query that has the argument, whatever its value.
+func ArgExists() ArgPredicate { return ArgPredicate{op: "exists"} }
+
+// ArgAbsent matches a query that does not have the argument.
+func ArgAbsent() ArgPredicate { return ArgPredicate{op: "absent"} }
+
+// ParseArgPredicate parses the expression form used by YAML match.args:
+//
+//	MHE                 equal (also "eq MHE" or "= MHE")
+//	ne MHE              not equal (also "!= MHE")
+//	in (MHE, WMD)       any of the listed values
+//	regex ^SO-\d+$      regular expression
+//	glob SO-*           glob pattern
+//	> 0, >= 0, < 5, <= 5  numeric comparisons (also gt, ge, lt, le)
+//	exists, absent      presence
+//
+// Values may be quoted with single or double quotes.
+func ParseArgPredicate(expr string) (ArgPredicate, error) {
+	expr = strings.TrimSpace(expr)
+	switch expr {
+	case "exists":
+		return ArgExists(), nil
+	case "absent":
+		return ArgAbsent(), nil
+	}
+	op, operand := "eq", expr
+	for _, prefix := range []string{">=", "<=", "!=", ">", "<", "="} {
+		if rest, ok := strings.CutPrefix(expr, prefix); ok {
+			op, operand = map[string]string{">=": "ge", "<=": "le", "!=": "ne", ">": "gt", "<": "lt", "=": "eq"}[prefix], rest
+			break
+		}
+	}
+	if word, rest, ok := strings.Cut(expr, " "); ok && op == "eq" && operand == expr {
+		switch word {
+		case "eq", "ne", "in", "regex", "glob", "gt", "ge", "lt", "le":
+			op, operand = word, rest
+		}
+	}
+	operand = strings.TrimSpace(operand)
+	switch op {
+	case "eq":
+		return ArgEq(unquoteArg(operand)), nil
+	case "ne":
+		return ArgNe(unquoteArg(operand)), nil
+	case "glob":
+		pattern := unquoteArg(operand)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return ArgPredicate{}, fmt.Errorf("glob %q: %w", pattern, err)
+		}
+		return ArgGlob(pattern), nil
+	case "regex":
+		re, err := regexp.Compile(unquoteArg(operand))
+		if err != nil {
+			return ArgPredicate{}, err
+		}
+		return ArgRegex(re), nil
+	case "in":
+		list, opened := strings.CutPrefix(operand, "(")
+		list, closed := strings.CutSuffix(list, ")")
+		if !opened || !closed {
+			return ArgPredicate{}, fmt.Errorf("in %q: values must be in parentheses", operand)
+		}
+		var values []string
+		for v := range strings.SplitSeq(list, ",") {
+			values = append(values, unquoteArg(strings.TrimSpace(v)))
+		}
+		return ArgIn(values...), nil
+	}
+	n, err := strconv.ParseFloat(unquoteArg(operand), 64)
+	if err != nil {
+		return ArgPredicate{}, fmt.Errorf("%s %q: not a number", op, operand)
+	}
+	return ArgPredicate{op: op, num: n}, nil
+}
+
+// unquoteArg strips one level of matching single or double quotes from s.
+func unquoteArg(s string) string {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// String returns p in the form ParseArgPredicate accepts.
+func (p ArgPredicate) String() string {
+	switch p.op {
+	case "exists", "absent":
+		return p.op
+	case "in":
+		return "in (" + strings.Join(p.values, ", ") + ")"
+	case "regex":
+		return "regex " + p.re.String()
+	case "gt", "ge", "lt", "le":
+		return p.op + " " + strconv.FormatFloat(p.num, 'g', -1, 64)
+	}
+	return p.op + " " + strings.Join(p.values, "")
+}
+
+// test reports whether the argument value, present or not, satisfies p.
+// With fold set, as for entries normalized with NormalizationLossy, string
+// comparisons ignore case.
+func (p ArgPredicate) test(value string, present, fold bool) bool {
+	switch p.op {
+	case "absent":
+		return !present
+	case "ne":
+		return !present || !equalArg(value, p.values[0], fold)
+	}
+	if !present {
+		return false
+	}
+	switch p.op {
+	case "eq", "in":
+		return slices.ContainsFunc(p.values, func(v string) bool { return equalArg(value, v, fold) })
+	case "regex":
+		if fold {
+			return p.fold.MatchString(value)
+		}
+		return p.re.MatchString(value)
+	case "glob":
+		pattern := p.values[0]
+		if fold {
+			pattern, value = strings.ToLower(pattern), strings.ToLower(value)
+		}
+		ok, _ := path.Match(pattern, value)
+		return ok
+	case "gt", "ge", "lt", "le":
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return false
+		}
+		switch p.op {
+		case "gt":
+			return n > p.num
+		case "ge":
+			return n >= p.num
+		case "lt":
+			return n < p.num
+		}
+		return n <= p.num
+	}
+	return true // exists
+}
+
+func equalArg(a, b string, fold bool) bool {
+	if fold {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// argsMatch reports whether args satisfies every predicate of e.
+func argsMatch(e Entry, args map[string]string) bool {
+	for name, p := range e.Args {
+		value, present := args[name]
+		if !p.test(value, present, e.Normalization != NormalizationLiteral) {
+			return false
+		}
+	}
+	return true
+}
+
+// queryArgs returns the arguments predicates are evaluated against: the
+// publish data context of query, overlaid with the "name = value"
+// arguments of its innermost command. @variable arguments are skipped.
+func queryArgs(query string, mode NormalizationMode) map[string]string {
+	node, err := parseQuery(query)
+	if err != nil {
+		return nil
+	}
+	ctx, inner, _ := flattenPublishData(node)
+	args := make(map[string]string, len(ctx))
+	for name, value := range normalizeContext(ctx, mode) {
+		args[strings.ToLower(name)] = value
+	}
+	if cmd, ok := inner.(*commandNode); ok {
+		for _, a := range cmd.args {
+			if a.name != "" && a.op == "=" && a.value.kind != valueVariable {
+				args[strings.ToLower(a.name)] = a.value.text
+			}
+		}
+	}
+	return args
+}
+
+// normalizeArgPredicates returns preds keyed by lowercased argument name.
+func normalizeArgPredicates(preds map[string]ArgPredicate) map[string]ArgPredicate {
+	if len(preds) == 0 {
+		return nil
+	}
+	normalized := make(map[string]ArgPredicate, len(preds))
+	for name, p := range preds {
+		normalized[strings.ToLower(strings.TrimSpace(name))] = p
+	}
+	return normalized
+}
@@ -0,0 +1,129 @@
+package mocka
+
+import (
+	"log/slog"
+	"regexp"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseArgPredicate(t *testing.T) {
+
+	Convey("ParseArgPredicate", t, func() {
+
+		test := func(expr, value string, present bool) bool {
+			p, err := ParseArgPredicate(expr)
+			So(err, ShouldBeNil)
+			return p.test(value, present, false)
+		}
+
+		Convey("treats a bare value as eq and strips quotes", func() {
+			So(test("WMD1", "WMD1", true), ShouldBeTrue)
+			So(test("eq 'WMD 1'", "WMD 1", true), ShouldBeTrue)
+			So(test("= WMD1", "WMD2", true), ShouldBeFalse)
+		})
+
+		Convey("parses ne, which an absent argument satisfies", func() {
+			So(test("ne WMD1", "WMD2", true), ShouldBeTrue)
+			So(test("!= WMD1", "WMD1", true), ShouldBeFalse)
+			So(test("ne WMD1", "", false), ShouldBeTrue)
+		})
+
+		Convey("parses in lists", func() {
+			So(test("in (WMD1, 'WMD2')", "WMD2", true), ShouldBeTrue)
+			So(test("in (WMD1, WMD2)", "WMD3", true), ShouldBeFalse)
+		})
+
+		Convey("parses regex and glob", func() {
+			So(test(`regex ^SO-\d+$`, "SO-42", true), ShouldBeTrue)
+			So(test(`regex ^SO-\d+$`, "SO-X", true), ShouldBeFalse)
+			So(test("glob SO-*", "SO-42", true), ShouldBeTrue)
+			So(test("glob SO-*", "PO-42", true), ShouldBeFalse)
+		})
+
+		Convey("parses numeric comparisons in symbol and word form", func() {
+			So(test("> 0", "5", true), ShouldBeTrue)
+			So(test("gt 5", "5", true), ShouldBeFalse)
+			So(test(">= 5", "5", true), ShouldBeTrue)
+			So(test("< 1.5", "1", true), ShouldBeTrue)
+			So(test("le 1", "2", true), ShouldBeFalse)
+			So(test("> 0", "abc", true), ShouldBeFalse)
+		})
+
+		Convey("parses exists and absent", func() {
+			So(test("exists", "", true), ShouldBeTrue)
+			So(test("exists", "", false), ShouldBeFalse)
+			So(test("absent", "", false), ShouldBeTrue)
+			So(test("absent", "x", true), ShouldBeFalse)
+		})
+
+		Convey("rejects malformed expressions", func() {
+			for _, expr := range []string{"> abc", "in WMD1", "in MHE, WMD)", "in (MHE, WMD", "regex (", "glob ["} {
+				_, err := ParseArgPredicate(expr)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestArgPredicate_Fold(t *testing.T) {
+
+	Convey("Given a fold comparison", t, func() {
+		Convey("Then string comparisons ignore case", func() {
+			So(ArgEq("WMD1").test("wmd1", true, true), ShouldBeTrue)
+			So(ArgIn("A", "B").test("b", true, true), ShouldBeTrue)
+			So(ArgRegex(regexp.MustCompile("^SO-")).test("so-1", true, true), ShouldBeTrue)
+			So(ArgGlob("SO-*").test("so-1", true, true), ShouldBeTrue)
+			So(ArgEq("WMD1").test("wmd1", true, false), ShouldBeFalse)
+		})
+	})
+}
+
+func TestMatchQuery_Args(t *testing.T) {
+
+	logger := slog.Default()
+
+	Convey("args predicates", t, func() {
+
+		entries, _ := NewInMemoryResponseLoader(
+			WithArgs(map[string]ArgPredicate{"WH_ID": ArgIn("WMD1", "WMD2"), "ordnum": ArgGlob("SO-*")},
+				WithPrefixMatch("list orders", NewResponse(StatusOK).WithMessage("sales").Build())),
+			WithArgs(map[string]ArgPredicate{"qty": ArgGt(100)},
+				WithPublishDataMatch("list orders", NewResponse(StatusOK).WithMessage("large").Build())),
+			WithNormalizedMatch(NormalizationLiteral, WithArgs(map[string]ArgPredicate{"descr": ArgEq("Mixed")},
+				WithPrefixMatch("list parts", NewResponse(StatusOK).WithMessage("literal").Build()))),
+			WithPrefixMatch("list", NewResponse(StatusOK).WithMessage("fallback").Build()),
+		).Load()
+		match := func(q string) string {
+			return matchQuery(normalizeLiteralQuery(q), entries, logger).Message
+		}
+
+		Convey("Given where-clause arguments satisfying every predicate", func() {
+			Convey("Then the entry matches, comparing case-insensitively", func() {
+				So(match("list orders where wh_id = 'wmd2' and ordnum = 'SO-1'"), ShouldEqual, "sales")
+			})
+		})
+
+		Convey("Given an argument failing a predicate", func() {
+			Convey("Then matching continues with later entries", func() {
+				So(match("list orders where wh_id = 'WMD3' and ordnum = 'SO-1'"), ShouldEqual, "fallback")
+				So(match("list orders where wh_id = 'WMD1'"), ShouldEqual, "fallback")
+			})
+		})
+
+		Convey("Given arguments supplied by publish data", func() {
+			Convey("Then predicates see the publish data context", func() {
+				So(match("publish data where qty = 500 | { list orders }"), ShouldEqual, "large")
+				So(match("publish data where qty = 5 | { list orders }"), ShouldStartWith, "Command (")
+			})
+		})
+
+		Convey("Given a literal entry", func() {
+			Convey("Then its predicates compare case", func() {
+				So(match("list parts where descr = 'Mixed'"), ShouldEqual, "literal")
+				So(match("list parts where descr = 'mixed'"), ShouldEqual, "fallback")
+			})
+		})
+	})
+}
@@ -0,0 +1,125 @@
+package mocka
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/castingcode/mocaprotocol"
+)
+
+// BuiltinCommand identifies an optional built-in MOCA framework command.
+// Unlike ping, login user and logout user, optional built-ins are disabled by
+// default; enable them with WithBuiltinCommands. Each can be overridden by a
+// Builtin entry in the same way as the mandatory built-ins, whether or not it
+// is enabled.
+type BuiltinCommand string
+
+const (
+	// BuiltinChangePassword accepts any password change and returns status 0.
+	BuiltinChangePassword BuiltinCommand = "change user password"
+	// BuiltinServerInfo returns a single row describing the server.
+	BuiltinServerInfo BuiltinCommand = "get server information"
+	// BuiltinSessionInfo returns a single row describing the caller's session.
+	BuiltinSessionInfo BuiltinCommand = "list session information"
+)
+
+// AllBuiltinCommands returns every optional built-in command.
+func AllBuiltinCommands() []BuiltinCommand {
+	return []BuiltinCommand{BuiltinChangePassword, BuiltinServerInfo, BuiltinSessionInfo}
+}
+
+// ParseBuiltinCommands converts a comma-separated list of command names, or
+// "all", to BuiltinCommands. An empty string yields no commands.
+func ParseBuiltinCommands(s string) ([]BuiltinCommand, error) {
+	if strings.TrimSpace(s) == "all" {
+		return AllBuiltinCommands(), nil
+	}
+	var cmds []BuiltinCommand
+	for _, name := range strings.Split(s, ",") {
+		name = normalizeQuery(name)
+		if name == "" {
+			continue
+		}
+		switch cmd := BuiltinCommand(name); cmd {
+		case BuiltinChangePassword, BuiltinServerInfo, BuiltinSessionInfo:
+			cmds = append(cmds, cmd)
+		default:
+			return nil, fmt.Errorf("unknown built-in command %q", name)
+		}
+	}
+	return cmds, nil
+}
+
+// handleBuiltinCommand handles an enabled optional built-in command that
+// has no Builtin override. The command requires a session and returns
+// mocka's default result set.
+func (h *MocaRequestHandler) handleBuiltinCommand(w *mocaResponseWriter, request mocaprotocol.MocaRequest, cmd BuiltinCommand) {
+	sessionKey, invalidKey := h.authorize(request, true)
+	if invalidKey != nil {
+		writeMocaResponse(w, *invalidKey)
+		return
+	}
+	switch cmd {
+	case BuiltinChangePassword:
+		writeMocaResponse(w, generatePingResponse())
+	case BuiltinServerInfo:
+		writeMocaResponse(w, generateServerInfoResponse(h.login))
+	case BuiltinSessionInfo:
+		userID, _ := h.sessions.Get(sessionKey)
+		writeMocaResponse(w, generateSessionInfoResponse(userID, sessionKey, h.loginProfileFor(userID)))
+	}
+}
+
+func generateServerInfoResponse(profile LoginProfile) mocaprotocol.MocaResponse {
+	response := mocaprotocol.MocaResponse{
+		MocaResults: mocaprotocol.MocaResults{
+			Metadata: mocaprotocol.Metadata{
+				Columns: []mocaprotocol.Column{
+					{Name: "moca_version", Type: mocaprotocol.MocaString, Nullable: "true", Length: "0"},
+					{Name: "prod_version", Type: mocaprotocol.MocaString, Nullable: "true", Length: "0"},
+					{Name: "srv_typ", Type: mocaprotocol.MocaString, Nullable: "true", Length: "0"},
+					{Name: "locale_id", Type: mocaprotocol.MocaString, Nullable: "true", Length: "0"},
+					{Name: "db_type", Type: mocaprotocol.MocaString, Nullable: "true", Length: "0"},
+				},
+			},
+			Data: mocaprotocol.Data{
+				Rows: []mocaprotocol.Row{
+					{Fields: []mocaprotocol.Field{
+						{Value: "2023.1.0"},
+						{Value: "2023.1.0"},
+						{Value: profile.SrvTyp},
+						{Value: profile.LocaleID},
+						{Value: "ORACLE"},
+					}},
+				},
+			},
+		},
+	}
+	return response
+}
+
+func generateSessionInfoResponse(userID, sessionKey string, profile LoginProfile) mocaprotocol.MocaResponse {
+	response := mocaprotocol.MocaResponse{
+		MocaResults: mocaprotocol.MocaResults{
+			Metadata: mocaprotocol.Metadata{
+				Columns: []mocaprotocol.Column{
+					{Name: "usr_id", Type: mocaprotocol.MocaString, Nullable: "true", Length: "0"},
+					{Name: "session_key", Type: mocaprotocol.MocaString, Nullable: "true", Length: "0"},
+					{Name: "locale_id", Type: mocaprotocol.MocaString, Nullable: "true", Length: "0"},
+					{Name: "srv_typ", Type: mocaprotocol.MocaString, Nullable: "true", Length: "0"},
+				},
+			},
+			Data: mocaprotocol.Data{
+				Rows: []mocaprotocol.Row{
+					{Fields: []mocaprotocol.Field{
+						{Value: userID},
+						{Value: sessionKey},
+						{Value: profile.LocaleID},
+						{Value: profile.SrvTyp},
+					}},
+				},
+			},
+		},
+	}
+	return response
+}
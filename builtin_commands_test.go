@@ -0,0 +1,130 @@
+package mocka
+
+import (
+	"net/http"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHandleMocaRequest_OptionalBuiltinCommands(t *testing.T) {
+
+	newMux := func(t *testing.T, loader *InMemoryResponseLoader, opts ...MocaRequestHandlerOption) *http.ServeMux {
+		lookup, err := NewResponseLookup(loader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler := NewMocaRequestHandler(lookup, append(opts, WithSession("k", "SUPER"))...)
+		mux := http.NewServeMux()
+		RegisterRoutes(mux, handler)
+		return mux
+	}
+
+	Convey("Given a handler with every optional built-in command enabled", t, func() {
+		mux := newMux(t, NewInMemoryResponseLoader(), WithBuiltinCommands(AllBuiltinCommands()...))
+
+		Convey("When I change a password", func() {
+			response := sendRequest(t, mux, "change user password where usr_id = 'SUPER' and usr_pswd = 'new'", WithSessionKey("k"))
+
+			Convey("Then the response is OK with no results", func() {
+				So(response.Status, ShouldEqual, StatusOK)
+				So(response.MocaResults.Data.Rows, ShouldHaveLength, 0)
+			})
+		})
+
+		Convey("When I get server information", func() {
+			response := sendRequest(t, mux, "get server information", WithSessionKey("k"))
+
+			Convey("Then a single row describing the server is returned", func() {
+				So(response.Status, ShouldEqual, StatusOK)
+				So(response.MocaResults.Metadata.Columns[2].Name, ShouldEqual, "srv_typ")
+				So(response.MocaResults.Data.Rows, ShouldHaveLength, 1)
+				So(response.MocaResults.Data.Rows[0].Fields[2].Value, ShouldEqual, "DEVELOPMENT")
+			})
+		})
+
+		Convey("When I list session information", func() {
+			response := sendRequest(t, mux, "list session information", WithSessionKey("k"))
+
+			Convey("Then the caller's session is described", func() {
+				So(response.Status, ShouldEqual, StatusOK)
+				So(response.MocaResults.Data.Rows, ShouldHaveLength, 1)
+				So(response.MocaResults.Data.Rows[0].Fields[0].Value, ShouldEqual, "SUPER")
+				So(response.MocaResults.Data.Rows[0].Fields[1].Value, ShouldEqual, "k")
+			})
+		})
+
+		Convey("When I run a built-in command without a session key", func() {
+			response := sendRequest(t, mux, "get server information")
+
+			Convey("Then the session key is rejected", func() {
+				So(response.Status, ShouldEqual, StatusInvalidSessionKey)
+			})
+		})
+	})
+
+	Convey("Given an optional built-in command that is not enabled", t, func() {
+		mux := newMux(t, NewInMemoryResponseLoader())
+
+		Convey("Then the command goes through normal matching", func() {
+			So(sendRequest(t, mux, "get server information", WithSessionKey("k")).Status, ShouldEqual, StatusCommandNotFound)
+		})
+	})
+
+	Convey("Given an enabled built-in command with an override", t, func() {
+		mux := newMux(t,
+			NewInMemoryResponseLoader(
+				WithBuiltinOverride(WithPrefixMatch("change user password", NewResponse(2964).WithMessage("Password reused").Build())),
+			),
+			WithBuiltinCommands(BuiltinChangePassword),
+		)
+
+		Convey("Then the override is returned", func() {
+			response := sendRequest(t, mux, "change user password where usr_pswd = 'old'", WithSessionKey("k"))
+			So(response.Status, ShouldEqual, 2964)
+			So(response.Message, ShouldEqual, "Password reused")
+		})
+	})
+
+	Convey("Given an override for a built-in command that is not enabled", t, func() {
+		mux := newMux(t, NewInMemoryResponseLoader(
+			WithBuiltinOverride(WithExactMatch("get server information", NewResponse(StatusDBError).WithMessage("down").Build())),
+		))
+
+		Convey("Then the override is still returned", func() {
+			response := sendRequest(t, mux, "get server information", WithSessionKey("k"))
+			So(response.Status, ShouldEqual, StatusDBError)
+			So(response.Message, ShouldEqual, "down")
+		})
+	})
+}
+
+func TestParseBuiltinCommands(t *testing.T) {
+
+	Convey("ParseBuiltinCommands", t, func() {
+
+		Convey("returns every command for all", func() {
+			cmds, err := ParseBuiltinCommands("all")
+			So(err, ShouldBeNil)
+			So(cmds, ShouldResemble, AllBuiltinCommands())
+		})
+
+		Convey("parses a comma-separated list regardless of case and spacing", func() {
+			cmds, err := ParseBuiltinCommands("Get Server Information,  change user  password")
+			So(err, ShouldBeNil)
+			So(cmds, ShouldResemble, []BuiltinCommand{BuiltinServerInfo, BuiltinChangePassword})
+		})
+
+		Convey("returns no commands for an empty string", func() {
+			cmds, err := ParseBuiltinCommands("")
+			So(err, ShouldBeNil)
+			So(cmds, ShouldBeEmpty)
+		})
+
+		Convey("rejects an unknown command", func() {
+			_, err := ParseBuiltinCommands("drop everything")
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "drop everything")
+		})
+	})
+}
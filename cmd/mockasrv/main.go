@@ -13,9 +13,57 @@ import (
 func main() {
 	port := flag.Int("port", 9000, "Port to run the web server on")
 	folder := flag.String("folder", "", "Folder to store mock data")
+	session := flag.String("session", string(mocka.SessionModeStrict), "Session enforcement: strict, disabled, auto or entry")
+	builtins := flag.String("builtins", "", "Comma-separated optional built-in commands to enable, or 'all'")
+	login := flag.String("login", "", "YAML file of login result set profiles")
+	validation := flag.String("validation", string(mocka.ValidationWarn), "Result set validation: off, warn or strict")
+	compression := flag.String("compression", string(mocka.CompressionAuto), "Response compression: auto, always or off")
+	normalization := flag.String("normalization", string(mocka.NormalizationLossy), "Query normalization for entries without their own: lossy or literal")
+	pipelines := flag.Bool("pipelines", false, "Evaluate unmatched piped and multi-statement queries stage by stage")
 	flag.Parse()
 
-	mux, err := buildMux(folder)
+	mode, err := mocka.ParseSessionMode(*session)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	level, err := mocka.ParseValidationLevel(*validation)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	cmds, err := mocka.ParseBuiltinCommands(*builtins)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	compress, err := mocka.ParseCompressionMode(*compression)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	normalize, err := mocka.ParseNormalizationMode(*normalization)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	opts := []mocka.MocaRequestHandlerOption{
+		mocka.WithSessionMode(mode),
+		mocka.WithBuiltinCommands(cmds...),
+		mocka.WithCompression(compress),
+	}
+	if *pipelines {
+		opts = append(opts, mocka.WithPipelineEvaluation())
+	}
+	if *login != "" {
+		loginOpts, err := mocka.LoadLoginProfiles(*login)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		opts = append(opts, loginOpts...)
+	}
+	mux, err := buildMux(folder, level, normalize, opts...)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -28,16 +76,19 @@ func main() {
 	}
 }
 
-func buildMux(folder *string) (*http.ServeMux, error) {
+func buildMux(folder *string, validation mocka.ValidationLevel, normalization mocka.NormalizationMode, opts ...mocka.MocaRequestHandlerOption) (*http.ServeMux, error) {
 	f, err := dataFolder(folder)
 	if err != nil {
 		return nil, err
 	}
-	lookup, err := mocka.NewResponseLookup(mocka.NewFileResponseLoader(f))
+	lookup, err := mocka.NewResponseLookup(mocka.NewFileResponseLoader(f,
+		mocka.WithFileValidation(validation),
+		mocka.WithFileNormalization(normalization),
+	))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create response lookup: %w", err)
 	}
-	handler := mocka.NewMocaRequestHandler(lookup)
+	handler := mocka.NewMocaRequestHandler(lookup, opts...)
 
 	mux := http.NewServeMux()
 	mocka.RegisterRoutes(mux, handler)
@@ -4,6 +4,8 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/castingcode/mocka"
 )
 
 func Test_dataFolder(t *testing.T) {
@@ -56,7 +58,7 @@ func Test_dataFolder(t *testing.T) {
 func Test_buildMux(t *testing.T) {
 	t.Run("valid folder", func(t *testing.T) {
 		tempDir := t.TempDir()
-		_, err := buildMux(&tempDir)
+		_, err := buildMux(&tempDir, mocka.ValidationWarn, mocka.NormalizationLossy)
 		if err != nil {
 			t.Fatalf("expected no error, got %v", err)
 		}
@@ -64,18 +66,32 @@ func Test_buildMux(t *testing.T) {
 
 	t.Run("invalid folder", func(t *testing.T) {
 		folderFlag := "/non/existent/folder"
-		_, err := buildMux(&folderFlag)
+		_, err := buildMux(&folderFlag, mocka.ValidationWarn, mocka.NormalizationLossy)
 		if err == nil {
 			t.Fatalf("expected error, got nil")
 		}
 	})
 
+	t.Run("strict validation rejects an invalid result set", func(t *testing.T) {
+		tempDir := t.TempDir()
+		responses := "responses:\n  - match: {type: exact, query: q}\n    response:\n      status: 0\n      columns: [{name: qty, type: I}]\n      rows: [[abc]]\n"
+		if err := os.WriteFile(filepath.Join(tempDir, "responses.yml"), []byte(responses), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := buildMux(&tempDir, mocka.ValidationWarn, mocka.NormalizationLossy); err != nil {
+			t.Fatalf("expected no error with warn, got %v", err)
+		}
+		if _, err := buildMux(&tempDir, mocka.ValidationStrict, mocka.NormalizationLossy); err == nil {
+			t.Fatalf("expected error with strict, got nil")
+		}
+	})
+
 	t.Run("malformed responses.yml", func(t *testing.T) {
 		tempDir := t.TempDir()
 		if err := os.WriteFile(filepath.Join(tempDir, "responses.yml"), []byte("responses:\n  - [unclosed"), 0644); err != nil {
 			t.Fatal(err)
 		}
-		_, err := buildMux(&tempDir)
+		_, err := buildMux(&tempDir, mocka.ValidationWarn, mocka.NormalizationLossy)
 		if err == nil {
 			t.Fatalf("expected error, got nil")
 		}
@@ -0,0 +1,149 @@
+package mocka
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CompressionMode controls when MocaRequestHandler compresses response
+// bodies. Compressed request bodies are always decoded.
+type CompressionMode string
+
+const (
+	// CompressionAuto compresses responses with gzip or deflate when the
+	// request's Accept-Encoding allows it. This is the default.
+	CompressionAuto CompressionMode = "auto"
+	// CompressionAlways compresses every response, using gzip unless the
+	// client only accepts deflate.
+	CompressionAlways CompressionMode = "always"
+	// CompressionOff never compresses responses.
+	CompressionOff CompressionMode = "off"
+)
+
+// ParseCompressionMode converts s to a CompressionMode, returning an error
+// for unrecognized values.
+func ParseCompressionMode(s string) (CompressionMode, error) {
+	switch m := CompressionMode(s); m {
+	case CompressionAuto, CompressionAlways, CompressionOff:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown compression mode %q", s)
+}
+
+// WithCompression sets when the handler compresses responses. The default
+// is CompressionAuto.
+func WithCompression(mode CompressionMode) MocaRequestHandlerOption {
+	return func(h *MocaRequestHandler) {
+		h.compression = mode
+	}
+}
+
+// errUnsupportedEncoding is returned by decodeRequestBody for a
+// Content-Encoding other than gzip or deflate.
+var errUnsupportedEncoding = errors.New("unsupported Content-Encoding")
+
+// decodeRequestBody replaces r.Body with a reader that decodes its
+// Content-Encoding.
+func decodeRequestBody(r *http.Request) error {
+	var (
+		body io.ReadCloser
+		err  error
+	)
+	switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return nil
+	case "gzip", "x-gzip":
+		body, err = gzip.NewReader(r.Body)
+	case "deflate":
+		body, err = zlib.NewReader(r.Body)
+	default:
+		return fmt.Errorf("%w %q", errUnsupportedEncoding, encoding)
+	}
+	if err != nil {
+		return fmt.Errorf("decoding %s request body: %w", r.Header.Get("Content-Encoding"), err)
+	}
+	r.Body = body
+	return nil
+}
+
+// compressResponse wraps w to compress the response body according to the
+// handler's CompressionMode and the request's Accept-Encoding. The returned
+// function must be called once the response has been written.
+func (h *MocaRequestHandler) compressResponse(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	if h.compression == CompressionOff {
+		return w, func() {}
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding == "" {
+		if h.compression != CompressionAlways {
+			return w, func() {}
+		}
+		encoding = "gzip"
+	}
+	var zw io.WriteCloser
+	if encoding == "gzip" {
+		zw = gzip.NewWriter(w)
+	} else {
+		zw = zlib.NewWriter(w)
+	}
+	w.Header().Set("Content-Encoding", encoding)
+	w.Header().Del("Content-Length")
+	cw := &compressedResponseWriter{ResponseWriter: w, w: zw}
+	return cw, func() {
+		if err := zw.Close(); err != nil {
+			h.logger.Error("error compressing response", "error", err)
+		}
+	}
+}
+
+// negotiateEncoding returns the response encoding preferred by an
+// Accept-Encoding header: gzip or deflate, or "" if neither is acceptable.
+// gzip wins ties.
+func negotiateEncoding(acceptEncoding string) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				weight = f
+			}
+		}
+		if name == "x-gzip" {
+			name = "gzip"
+		}
+		q[name] = weight
+	}
+	for _, name := range []string{"gzip", "deflate"} {
+		if _, ok := q[name]; !ok {
+			if wildcard, ok := q["*"]; ok {
+				q[name] = wildcard
+			}
+		}
+	}
+	best := ""
+	for _, name := range []string{"gzip", "deflate"} {
+		if q[name] > 0 && (best == "" || q[name] > q[best]) {
+			best = name
+		}
+	}
+	return best
+}
+
+// compressedResponseWriter writes the response body through a compressor.
+type compressedResponseWriter struct {
+	http.ResponseWriter
+	w io.Writer
+}
+
+func (c *compressedResponseWriter) Write(b []byte) (int, error) {
+	return c.w.Write(b)
+}
@@ -0,0 +1,162 @@
+package mocka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/castingcode/mocaprotocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func compressionMux(t *testing.T, opts ...MocaRequestHandlerOption) *http.ServeMux {
+	t.Helper()
+	lookup, err := NewResponseLookup(NewInMemoryResponseLoader(
+		WithExactMatch("list warehouses", NewResponse(StatusOK).WithMessage("ok").Build()),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	RegisterRoutes(mux, NewMocaRequestHandler(lookup, append([]MocaRequestHandlerOption{WithSessionMode(SessionModeDisabled)}, opts...)...))
+	return mux
+}
+
+// decodeBody decompresses a recorded response body according to its
+// Content-Encoding and parses the MOCA response.
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder) mocaprotocol.MocaResponse {
+	t.Helper()
+	var body io.Reader = w.Body
+	switch w.Header().Get("Content-Encoding") {
+	case "gzip":
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = zr
+	case "deflate":
+		zr, err := zlib.NewReader(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = zr
+	}
+	var response mocaprotocol.MocaResponse
+	if err := xml.NewDecoder(body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func TestHandleMocaRequest_Compression(t *testing.T) {
+
+	Convey("Given a MocaRequestHandler", t, func() {
+
+		send := func(mux *http.ServeMux, acceptEncoding string) *httptest.ResponseRecorder {
+			req := buildRequest(t, "list warehouses")
+			if acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			return w
+		}
+
+		Convey("responses are uncompressed when the client does not ask", func() {
+			w := send(compressionMux(t), "")
+			So(w.Header().Get("Content-Encoding"), ShouldBeEmpty)
+			So(decodeBody(t, w).Message, ShouldEqual, "ok")
+		})
+
+		Convey("responses are gzipped when the client accepts gzip", func() {
+			w := send(compressionMux(t), "deflate, gzip")
+			So(w.Header().Get("Content-Encoding"), ShouldEqual, "gzip")
+			So(w.Header().Get("Vary"), ShouldEqual, "Accept-Encoding")
+			So(decodeBody(t, w).Message, ShouldEqual, "ok")
+		})
+
+		Convey("the client's quality values are honored", func() {
+			w := send(compressionMux(t), "gzip;q=0.5, deflate")
+			So(w.Header().Get("Content-Encoding"), ShouldEqual, "deflate")
+			So(decodeBody(t, w).Message, ShouldEqual, "ok")
+
+			w = send(compressionMux(t), "gzip;q=0, *;q=0")
+			So(w.Header().Get("Content-Encoding"), ShouldBeEmpty)
+		})
+
+		Convey("CompressionAlways compresses without Accept-Encoding", func() {
+			w := send(compressionMux(t, WithCompression(CompressionAlways)), "")
+			So(w.Header().Get("Content-Encoding"), ShouldEqual, "gzip")
+			So(decodeBody(t, w).Message, ShouldEqual, "ok")
+		})
+
+		Convey("CompressionOff ignores Accept-Encoding", func() {
+			w := send(compressionMux(t, WithCompression(CompressionOff)), "gzip")
+			So(w.Header().Get("Content-Encoding"), ShouldBeEmpty)
+			So(decodeBody(t, w).Message, ShouldEqual, "ok")
+		})
+	})
+
+	Convey("Given a compressed request body", t, func() {
+
+		compressed := func(encoding string) *http.Request {
+			req := buildRequest(t, "list warehouses")
+			raw, _ := io.ReadAll(req.Body)
+			var buf bytes.Buffer
+			var zw io.WriteCloser = gzip.NewWriter(&buf)
+			if encoding == "deflate" {
+				zw = zlib.NewWriter(&buf)
+			}
+			zw.Write(raw)
+			zw.Close()
+			req.Body = io.NopCloser(&buf)
+			req.Header.Set("Content-Encoding", encoding)
+			return req
+		}
+
+		for _, encoding := range []string{"gzip", "deflate"} {
+			Convey("it is decoded transparently with "+encoding, func() {
+				w := httptest.NewRecorder()
+				compressionMux(t).ServeHTTP(w, compressed(encoding))
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(decodeBody(t, w).Message, ShouldEqual, "ok")
+			})
+		}
+
+		Convey("an unsupported encoding is rejected with 415", func() {
+			w := httptest.NewRecorder()
+			req := buildRequest(t, "list warehouses")
+			req.Header.Set("Content-Encoding", "br")
+			compressionMux(t).ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, http.StatusUnsupportedMediaType)
+		})
+
+		Convey("a corrupt body is rejected with 400", func() {
+			w := httptest.NewRecorder()
+			req := buildRequest(t, "list warehouses")
+			req.Header.Set("Content-Encoding", "gzip")
+			req.Body = io.NopCloser(strings.NewReader("not gzip"))
+			compressionMux(t).ServeHTTP(w, req)
+			So(w.Code, ShouldEqual, http.StatusBadRequest)
+		})
+	})
+}
+
+func TestParseCompressionMode(t *testing.T) {
+
+	Convey("ParseCompressionMode", t, func() {
+		for _, s := range []string{"auto", "always", "off"} {
+			mode, err := ParseCompressionMode(s)
+			So(err, ShouldBeNil)
+			So(string(mode), ShouldEqual, s)
+		}
+		_, err := ParseCompressionMode("sometimes")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,210 @@
+package mocka
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// groovyScript is the canonical form of an embedded "[[...]]" Groovy script
+// used by groovy entries. Comments are stripped by normalization; strings are
+// requoted with single quotes and semicolons dropped.
+type groovyScript struct {
+	tokens      []string
+	text        string // tokens with spaces only between adjacent words
+	fingerprint string
+}
+
+// GroovyFingerprint returns the fingerprint a groovy entry compares scripts
+// by, for script normalized in mode. Scripts that differ only in comments,
+// whitespace, string quoting, semicolons or the names of variables declared
+// with def have the same fingerprint.
+func GroovyFingerprint(script string, mode NormalizationMode) string {
+	return canonicalGroovy(script, mode).fingerprint
+}
+
+// canonicalGroovy strips the comments from script, with or without its
+// brackets, normalizes it in mode and canonicalizes it.
+func canonicalGroovy(script string, mode NormalizationMode) groovyScript {
+	script = strings.TrimSpace(script)
+	if !strings.HasPrefix(script, "[[") {
+		script = "[[" + script + "]]"
+	}
+	normalized := normalizeQueryMode(stripGroovyComments(script), mode)
+	return parseGroovy(strings.TrimSuffix(strings.TrimPrefix(normalized, "[["), "]]"))
+}
+
+// parseGroovy canonicalizes the normalized source of a Groovy script.
+func parseGroovy(src string) groovyScript {
+	tokens := tokenizeGroovy(src)
+	// Rename variables declared with def to $1, $2, ... in order of
+	// declaration, except where they follow a dot as property names.
+	renamed := slices.Clone(tokens)
+	names := make(map[string]string)
+	for i, tok := range tokens {
+		if tok == "def" && i+1 < len(tokens) && isGroovyWord(tokens[i+1]) {
+			if _, ok := names[tokens[i+1]]; !ok {
+				names[tokens[i+1]] = "$" + strconv.Itoa(len(names)+1)
+			}
+		}
+	}
+	for i, tok := range renamed {
+		if name, ok := names[tok]; ok && (i == 0 || tokens[i-1] != ".") {
+			renamed[i] = name
+		}
+	}
+	sum := sha256.Sum256([]byte(strings.Join(renamed, " ")))
+	return groovyScript{tokens: tokens, text: renderGroovy(tokens), fingerprint: hex.EncodeToString(sum[:8])}
+}
+
+// tokenizeGroovy splits Groovy source into words, numbers, strings and
+// single-character symbols. Strings are requoted as '...' with quotes
+// escaped by backslashes.
+func tokenizeGroovy(src string) []string {
+	var tokens []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ';':
+			i++
+		case c == '\'' || c == '"':
+			i = groovyStringEnd(src, i)
+			tokens = append(tokens, requoteGroovy(src[start:i]))
+		case isSQLDigit(c):
+			for i < len(src) && (isSQLWordByte(src[i]) || isSQLDigit(src[i]) || src[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, src[start:i])
+		case isSQLWordByte(c):
+			for i < len(src) && (isSQLWordByte(src[i]) || isSQLDigit(src[i])) {
+				i++
+			}
+			tokens = append(tokens, src[start:i])
+		default:
+			i++
+			tokens = append(tokens, src[start:i])
+		}
+	}
+	return tokens
+}
+
+// requoteGroovy rewrites a Groovy string literal with single quotes.
+func requoteGroovy(lit string) string {
+	delim := lit[:1]
+	if strings.HasPrefix(lit, delim+delim+delim) && len(lit) >= 6 {
+		delim = lit[:3]
+	}
+	body := strings.TrimSuffix(strings.TrimPrefix(lit, delim), delim)
+	var b strings.Builder
+	b.WriteByte('\'')
+	for i := 0; i < len(body); i++ {
+		switch c := body[i]; {
+		case c == '\\' && i+1 < len(body) && (body[i+1] == '\'' || body[i+1] == '"'):
+			i++
+			if body[i] == '\'' {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(body[i])
+		case c == '\\' && i+1 < len(body):
+			b.WriteByte(c)
+			i++
+			b.WriteByte(body[i])
+		case c == '\'':
+			b.WriteString(`\'`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+func isGroovyWord(tok string) bool {
+	return tok != "" && isSQLWordByte(tok[0])
+}
+
+// renderGroovy joins tokens with a space only between two words, numbers or
+// strings, so "foo ( a )" and "foo(a)" render the same.
+func renderGroovy(tokens []string) string {
+	var b strings.Builder
+	spaced := func(tok string) bool {
+		return isSQLWordByte(tok[0]) || isSQLDigit(tok[0]) || tok[0] == '\''
+	}
+	for i, tok := range tokens {
+		if i > 0 && spaced(tok) && spaced(tokens[i-1]) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(tok)
+	}
+	return b.String()
+}
+
+// parseGroovyQuery returns the canonical script of query, normalized in
+// mode, if it is a single embedded Groovy script, optionally wrapped in a
+// block. Line comments are only stripped if query is not normalized yet.
+func parseGroovyQuery(query string, mode NormalizationMode) (groovyScript, bool) {
+	node, err := parseQuery(normalizeQueryMode(stripGroovyComments(query), mode))
+	if err != nil {
+		return groovyScript{}, false
+	}
+	for {
+		switch n := node.(type) {
+		case *blockNode:
+			node = n.body
+			continue
+		case *groovyNode:
+			return parseGroovy(n.text), true
+		}
+		return groovyScript{}, false
+	}
+}
+
+// groovyMatches reports whether script satisfies every constraint set on
+// the groovy entry e: its fingerprint, its snippets, which must each appear
+// as a contiguous run of tokens, and its patterns, which are matched against
+// the script's canonical text.
+func groovyMatches(e Entry, script groovyScript) bool {
+	if e.Fingerprint != "" && e.Fingerprint != script.fingerprint {
+		return false
+	}
+	for _, snippet := range e.Snippets {
+		if !containsTokens(script.tokens, tokenizeGroovy(snippet)) {
+			return false
+		}
+	}
+	for _, re := range e.Patterns {
+		if !re.MatchString(script.text) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsTokens reports whether sub appears as a contiguous run in tokens.
+func containsTokens(tokens, sub []string) bool {
+	for i := 0; i+len(sub) <= len(tokens); i++ {
+		if slices.Equal(tokens[i:i+len(sub)], sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// setGroovyPattern stores the patterns of the groovy entry e, normalized in
+// e's mode. script, when set, is reduced to its fingerprint; snippets are
+// stored in canonical text form.
+func (e *Entry) setGroovyPattern(script, fingerprint string, snippets []string, patterns []*regexp.Regexp) {
+	e.Fingerprint = strings.ToLower(strings.TrimSpace(fingerprint))
+	if strings.TrimSpace(script) != "" {
+		e.Fingerprint = GroovyFingerprint(script, e.Normalization)
+	}
+	e.Snippets = nil
+	for _, snippet := range snippets {
+		e.Snippets = append(e.Snippets, canonicalGroovy(snippet, e.Normalization).text)
+	}
+	e.Patterns = patterns
+}
@@ -0,0 +1,117 @@
+package mocka
+
+import (
+	"log/slog"
+	"regexp"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGroovyFingerprint(t *testing.T) {
+
+	Convey("GroovyFingerprint", t, func() {
+		script := `
+			// count the rows
+			def rows = moca.executeCommand("list locations")
+			return rows.size() /* total */;
+		`
+		fp := GroovyFingerprint(script, NormalizationLossy)
+
+		Convey("ignores comments, whitespace, quoting and semicolons", func() {
+			So(GroovyFingerprint(`def rows = moca.executeCommand('list locations')
+return rows.size()`, NormalizationLossy), ShouldEqual, fp)
+		})
+
+		Convey("ignores the names of def variables", func() {
+			So(GroovyFingerprint(`def result = moca.executeCommand("list locations"); return result.size()`, NormalizationLossy), ShouldEqual, fp)
+		})
+
+		Convey("does not rename property names", func() {
+			So(GroovyFingerprint("def size = 1; return x.size", NormalizationLossy), ShouldNotEqual, GroovyFingerprint("def n = 1; return x.n", NormalizationLossy))
+		})
+
+		Convey("changes when the code changes", func() {
+			So(GroovyFingerprint(`def rows = moca.executeCommand("list areas"); return rows.size()`, NormalizationLossy), ShouldNotEqual, fp)
+		})
+
+		Convey("accepts the script with or without brackets", func() {
+			So(GroovyFingerprint("[["+script+"]]", NormalizationLossy), ShouldEqual, fp)
+		})
+	})
+}
+
+func TestStripGroovyComments(t *testing.T) {
+
+	Convey("stripGroovyComments", t, func() {
+
+		Convey("line and block comments are removed", func() {
+			So(normalizeQuery(stripGroovyComments("[[ a = 1 // one\n b = 2 /* two */ ]]")), ShouldEqual, "[[a = 1 b = 2]]")
+		})
+
+		Convey("comment markers inside strings are kept", func() {
+			So(stripGroovyComments(`[[ url = "http://host/*x*/" ]]`), ShouldEqual, `[[ url = "http://host/*x*/" ]]`)
+		})
+
+		Convey("local syntax and SQL are untouched", func() {
+			So(stripGroovyComments("[select 1 // 2 from dual]"), ShouldEqual, "[select 1 // 2 from dual]")
+		})
+
+		Convey("normalization keeps comments", func() {
+			So(normalizeQuery("[[ a = 1 /* one */ ]]"), ShouldEqual, "[[a = 1 /* one */]]")
+			So(normalizeLiteralQuery("[[ a = 1 // one\n ]]"), ShouldEqual, "[[a = 1 // one]]")
+		})
+	})
+}
+
+func TestMatchQuery_Groovy(t *testing.T) {
+
+	logger := slog.Default()
+
+	Convey("groovy matching", t, func() {
+
+		entries, _ := NewInMemoryResponseLoader(
+			WithGroovyMatch(`def rows = moca.executeCommand("list locations"); return rows.size()`, NewResponse(StatusOK).WithMessage("fingerprint").Build()),
+			WithGroovySnippetMatch(
+				[]string{"moca.executeCommand('list areas')"},
+				[]*regexp.Regexp{regexp.MustCompile(`return \$?\w+\.size\(\)`)},
+				NewResponse(StatusOK).WithMessage("snippets").Build(),
+			),
+		).Load()
+		match := func(q string) string {
+			return matchQuery(q, entries, logger).Message
+		}
+
+		Convey("Given the script with a new comment and variable name", func() {
+			Convey("Then the fingerprint entry matches", func() {
+				So(match("[[ // refactored\n def count = moca.executeCommand('list locations')\n return count.size() ]]"), ShouldEqual, "fingerprint")
+			})
+
+			Convey("Then an exact entry for the commented script does not match the bare script", func() {
+				entries, _ := NewInMemoryResponseLoader(
+					WithExactMatch("[[ /* v2 */ return 1 ]]", NewResponse(StatusOK).WithMessage("exact").Build()),
+				).Load()
+				So(matchQuery("[[ return 1 ]]", entries, logger).Message, ShouldStartWith, "Command (")
+				So(matchQuery("[[ /* v2 */ return 1 ]]", entries, logger).Message, ShouldEqual, "exact")
+			})
+		})
+
+		Convey("Given a script containing the snippet and matching the pattern", func() {
+			Convey("Then the snippet entry matches", func() {
+				So(match(`[[ def a = 1; def r = moca.executeCommand( "list areas" ); return r.size() ]]`), ShouldEqual, "snippets")
+			})
+		})
+
+		Convey("Given a script containing the snippet but not matching the pattern", func() {
+			Convey("Then nothing matches", func() {
+				So(match(`[[ moca.executeCommand("list areas") ]]`), ShouldStartWith, "Command (")
+			})
+		})
+
+		Convey("Given a local syntax query", func() {
+			Convey("Then groovy entries are not consulted", func() {
+				So(match("list areas"), ShouldStartWith, "Command (")
+			})
+		})
+	})
+}
@@ -2,11 +2,13 @@ package mocka
 
 import (
 	"encoding/xml"
+	"errors"
 	"log/slog"
 	"net/http"
-	"strings"
+	"slices"
 
 	"github.com/castingcode/mocaprotocol"
+	"github.com/google/uuid"
 )
 
 var XMLDeclaration = []byte(`<?xml version="1.0" encoding="UTF-8"?>`)
@@ -25,27 +27,106 @@ func RegisterRoutes(router Router, handler MocaRequestHandlerInterface) {
 }
 
 type MocaRequestHandler struct {
-	lookup   *ResponseLookup
-	sessions *SessionStore
-	logger   *slog.Logger
+	lookup        *ResponseLookup
+	sessions      *SessionStore
+	newSessionKey SessionKeyGenerator
+	sessionMode   SessionMode
+	builtins      map[BuiltinCommand]bool
+	login         LoginProfile
+	userLogins    map[string]LoginProfile
+	compression   CompressionMode
+	encoders      map[string]ResponseEncoder // by lowercased content type
+	pipelines     bool
+	logger        *slog.Logger
 }
 
 var _ MocaRequestHandlerInterface = (*MocaRequestHandler)(nil)
 
-func NewMocaRequestHandler(lookup *ResponseLookup) *MocaRequestHandler {
-	return &MocaRequestHandler{
-		lookup:   lookup,
-		sessions: newSessionStore(),
-		logger:   slog.Default(),
+// MocaRequestHandlerOption configures a MocaRequestHandler.
+type MocaRequestHandlerOption func(*MocaRequestHandler)
+
+// NewMocaRequestHandler creates a MocaRequestHandler that resolves queries
+// against lookup. Options are applied in order.
+func NewMocaRequestHandler(lookup *ResponseLookup, opts ...MocaRequestHandlerOption) *MocaRequestHandler {
+	h := &MocaRequestHandler{
+		lookup:        lookup,
+		sessions:      newSessionStore(),
+		newSessionKey: uuid.NewString,
+		sessionMode:   SessionModeStrict,
+		builtins:      make(map[BuiltinCommand]bool),
+		login:         DefaultLoginProfile(),
+		userLogins:    make(map[string]LoginProfile),
+		compression:   CompressionAuto,
+		encoders: map[string]ResponseEncoder{
+			XMLEncoder{}.ContentType():           XMLEncoder{},
+			CompressedXMLEncoder{}.ContentType(): CompressedXMLEncoder{},
+		},
+		logger: slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// WithSessionKeyGenerator replaces the default UUID session key generator
+// used at login. See SequentialSessionKeys and SeededSessionKeys for
+// deterministic generators.
+func WithSessionKeyGenerator(gen SessionKeyGenerator) MocaRequestHandlerOption {
+	return func(h *MocaRequestHandler) {
+		h.newSessionKey = gen
 	}
 }
 
-func (h *MocaRequestHandler) HandleMocaRequest(w http.ResponseWriter, r *http.Request) {
+// WithSessionMode sets when the handler requires a valid session key.
+// The default is SessionModeStrict.
+func WithSessionMode(mode SessionMode) MocaRequestHandlerOption {
+	return func(h *MocaRequestHandler) {
+		h.sessionMode = mode
+	}
+}
+
+// WithBuiltinCommands enables optional built-in commands such as
+// BuiltinServerInfo. Use AllBuiltinCommands to enable every one.
+func WithBuiltinCommands(cmds ...BuiltinCommand) MocaRequestHandlerOption {
+	return func(h *MocaRequestHandler) {
+		for _, cmd := range cmds {
+			h.builtins[cmd] = true
+		}
+	}
+}
+
+// WithSession pre-provisions a session for userID under key, so tests can
+// send authenticated requests without performing a login round-trip.
+func WithSession(key, userID string) MocaRequestHandlerOption {
+	return func(h *MocaRequestHandler) {
+		h.sessions.Add(key, userID)
+	}
+}
+
+// Sessions returns the handler's session store.
+func (h *MocaRequestHandler) Sessions() *SessionStore {
+	return h.sessions
+}
+
+func (h *MocaRequestHandler) HandleMocaRequest(rw http.ResponseWriter, r *http.Request) {
+	rw, done := h.compressResponse(rw, r)
+	defer done()
+	rw.Header().Add("Vary", "Accept")
+	w := &mocaResponseWriter{ResponseWriter: rw, encoder: h.negotiateEncoder(r.Header.Get("Accept"))}
 	if r.Header.Get("Content-Type") != "application/moca-xml" {
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		w.Write(generateNoContentResponse())
 		return
 	}
+	if err := decodeRequestBody(r); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errUnsupportedEncoding) {
+			status = http.StatusUnsupportedMediaType
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
 	var request mocaprotocol.MocaRequest
 	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
@@ -53,83 +134,193 @@ func (h *MocaRequestHandler) HandleMocaRequest(w http.ResponseWriter, r *http.Re
 	}
 
 	query := normalizeQuery(request.Query.Text)
-	if !strings.HasPrefix(query, "[") {
-		tokens := strings.SplitN(query, " where ", 2)
-		switch tokens[0] {
+	if inner, ok := loginInnerQuery(query); ok {
+		h.handleLogin(w, inner)
+		return
+	}
+	if cmd, ok := parseCommand(query); ok {
+		switch cmd.verb {
 		case "ping":
+			if override, ok := h.lookup.GetOverride(query); ok {
+				h.writeResponse(w, override)
+				return
+			}
 			writeMocaResponse(w, generatePingResponse())
 			return
 		case "logout user":
-			sessionKey, invalidKey := h.sessions.GetSessionKey(request)
+			if override, ok := h.lookup.GetOverride(query); ok {
+				if override.StatusCode == StatusOK {
+					// A successful override ends the session, so it is
+					// authorized like the default logout.
+					sessionKey, invalidKey := h.authorize(request, true)
+					if invalidKey != nil {
+						writeMocaResponse(w, *invalidKey)
+						return
+					}
+					h.sessions.Delete(sessionKey)
+				}
+				h.writeResponse(w, override)
+				return
+			}
+			sessionKey, invalidKey := h.authorize(request, true)
 			if invalidKey != nil {
-				writeMocaResponse(w, invalidKey)
+				writeMocaResponse(w, *invalidKey)
 				return
 			}
 			h.sessions.Delete(sessionKey)
 			writeMocaResponse(w, generatePingResponse())
 			return
 		default:
-			if inner, ok := loginInnerQuery(query); ok {
-				h.handleLogin(w, strings.SplitN(inner, " where ", 2))
+			builtin := BuiltinCommand(cmd.verb)
+			if !slices.Contains(AllBuiltinCommands(), builtin) {
+				break
+			}
+			// Overrides apply to optional built-ins whether or not they
+			// are enabled.
+			if override, ok := h.lookup.GetOverride(query); ok {
+				h.writeResponse(w, override)
+				return
+			}
+			if h.builtins[builtin] {
+				h.handleBuiltinCommand(w, request, builtin)
 				return
 			}
 		}
 	}
 
-	_, invalidKey := h.sessions.GetSessionKey(request)
-	if invalidKey != nil {
-		writeMocaResponse(w, invalidKey)
+	// Look up the raw query so that entries normalized with
+	// NormalizationLiteral can see literal contents and Groovy comments can
+	// be stripped before newlines are collapsed.
+	literal := normalizeLiteralQuery(request.Query.Text)
+	response := h.lookup.GetResponse(request.Query.Text)
+	env := environmentVars(request)
+	if response.StatusCode == StatusCommandNotFound {
+		// Retry with @variables bound from publish data and the environment.
+		if bound, ok := bindQueryVariables(literal, env); ok {
+			if r := h.lookup.GetResponse(bound); r.StatusCode != StatusCommandNotFound {
+				response = r
+			}
+		}
+	}
+	if response.StatusCode == StatusCommandNotFound && h.pipelines {
+		if evaluated, ok := h.evaluatePipeline(literal, env); ok {
+			response = evaluated
+		}
+	}
+	if _, invalidKey := h.authorize(request, response.RequireSession); invalidKey != nil {
+		writeMocaResponse(w, *invalidKey)
 		return
 	}
-	response := h.lookup.GetResponse(query)
+	h.writeResponse(w, response)
+}
 
+// writeResponse streams response to w with the request's ResponseEncoder. A
+// result set that cannot be generated or parsed produces an HTTP 500.
+func (h *MocaRequestHandler) writeResponse(w *mocaResponseWriter, response Response) {
+	results, err := response.results()
+	if err != nil {
+		h.logger.Error("error unmarshalling response", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 	mocaResponse := mocaprotocol.MocaResponse{
 		Status:  response.StatusCode,
 		Message: response.Message,
 	}
-	if response.ResultSet != "" {
-		if err := xml.Unmarshal([]byte(response.ResultSet), &mocaResponse.MocaResults); err != nil {
-			h.logger.Error("error unmarshalling response", "error", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
+	if results != nil {
+		mocaResponse.MocaResults = *results
 	}
-	body, err := xml.Marshal(mocaResponse)
-	if err != nil {
-		h.logger.Error("error marshalling response", "error", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	if err := writeMocaResponse(w, mocaResponse); err != nil {
+		h.logger.Error("error writing response", "error", err)
 	}
-	writeMocaResponse(w, append(XMLDeclaration, body...))
 }
 
-func (h *MocaRequestHandler) handleLogin(w http.ResponseWriter, tokens []string) {
-	if len(tokens) < 2 {
-		writeMocaResponse(w, generateErrorResponse(802, "Missing argument: Password (usr_pswd)"))
-		return
-	}
+// handleLogin handles the normalized "login user ..." command inner. A
+// registered Builtin override takes precedence over the default behavior.
+func (h *MocaRequestHandler) handleLogin(w *mocaResponseWriter, inner string) {
 	params := make(map[string]string)
-	for _, cond := range strings.Split(tokens[1], " and ") {
-		parts := strings.SplitN(cond, " = ", 2)
-		if len(parts) == 2 {
-			val := strings.TrimSpace(parts[1])
-			val = strings.Trim(val, "'")
-			val = strings.Trim(val, `"`)
-			params[strings.TrimSpace(parts[0])] = val
-		}
+	if cmd, ok := parseCommand(inner); ok {
+		params = cmd.equalArgs()
+	}
+	if override, ok := h.lookup.GetOverride(inner); ok {
+		h.handleLoginOverride(w, override, params["usr_id"])
+		return
 	}
 	if params["usr_pswd"] == "" {
 		writeMocaResponse(w, generateErrorResponse(802, "Missing argument: Password (usr_pswd)"))
 		return
 	}
-	response, sessionKey := generateLoginResponse(params["usr_id"])
+	sessionKey := h.newSessionKey()
+	response := generateLoginResponse(params["usr_id"], sessionKey, h.loginProfileFor(params["usr_id"]))
 	h.sessions.Add(sessionKey, params["usr_id"])
 	writeMocaResponse(w, response)
 }
 
-func writeMocaResponse(w http.ResponseWriter, body []byte) {
-	w.Header().Set("Content-Type", "application/moca-xml")
-	w.Write(body)
+// handleLoginOverride writes a login override response. A failing override
+// is written as-is. A successful override with no result set is replaced by
+// the standard login result set under a newly generated session key; one with
+// a result set registers the value of its session_key column, if present.
+func (h *MocaRequestHandler) handleLoginOverride(w *mocaResponseWriter, override Response, userID string) {
+	if override.StatusCode != StatusOK {
+		h.writeResponse(w, override)
+		return
+	}
+	if override.ResultSet == "" && override.lazy == nil {
+		sessionKey := h.newSessionKey()
+		h.sessions.Add(sessionKey, userID)
+		writeMocaResponse(w, generateLoginResponse(userID, sessionKey, h.loginProfileFor(userID)))
+		return
+	}
+	if results, err := override.results(); err == nil && results != nil {
+		if sessionKey, ok := firstValue(*results, "session_key"); ok {
+			h.sessions.Add(sessionKey, userID)
+		} else {
+			h.logger.Warn("login override has no session_key column; no session created")
+		}
+	}
+	h.writeResponse(w, override)
+}
+
+// firstValue returns the value of column in the first row of results.
+func firstValue(results mocaprotocol.MocaResults, column string) (string, bool) {
+	if len(results.Data.Rows) == 0 {
+		return "", false
+	}
+	for i, c := range results.Metadata.Columns {
+		if c.Name == column && i < len(results.Data.Rows[0].Fields) {
+			return results.Data.Rows[0].Fields[i].Value, true
+		}
+	}
+	return "", false
+}
+
+// authorize applies the handler's SessionMode to request and returns the
+// session key, or an error response if the request must be rejected.
+// required reports whether the matched entry demands a session; it is only
+// consulted in SessionModeEntry.
+func (h *MocaRequestHandler) authorize(request mocaprotocol.MocaRequest, required bool) (string, *mocaprotocol.MocaResponse) {
+	switch h.sessionMode {
+	case SessionModeDisabled:
+		key, _ := sessionKeyVar(request)
+		return key, nil
+	case SessionModeAutoCreate:
+		if key, ok := sessionKeyVar(request); ok {
+			if _, known := h.sessions.Get(key); !known {
+				h.logger.Debug("auto-creating session", "session_key", key)
+				h.sessions.Add(key, "")
+			}
+		}
+	case SessionModeEntry:
+		if !required {
+			key, _ := sessionKeyVar(request)
+			return key, nil
+		}
+	}
+	if key, ok := h.sessions.validKey(request); ok {
+		return key, nil
+	}
+	rejection := generateInvalidSessionResponse()
+	return "", &rejection
 }
 
 // isLoginCommand returns true if the query is a login user command, optionally
@@ -145,25 +336,15 @@ func isLoginCommand(query string) bool {
 // whether the query is a login command.
 func loginInnerQuery(query string) (string, bool) {
 	normalizedQuery := normalizeQuery(query)
-
-	if strings.HasPrefix(normalizedQuery, "publish data") {
-		pipeIdx := strings.Index(normalizedQuery, "| {")
-		if pipeIdx < 0 {
-			return "", false
-		}
-		innerPart := strings.TrimSpace(normalizedQuery[pipeIdx+3:])
-		if !strings.HasSuffix(innerPart, "}") {
-			return "", false
-		}
-		inner := normalizeQuery(strings.TrimSuffix(innerPart, "}"))
-		if !strings.HasPrefix(inner, "login user") {
-			return "", false
-		}
-		return inner, true
+	node, err := parseQuery(normalizedQuery)
+	if err != nil {
+		return "", false
 	}
-
-	if strings.HasPrefix(normalizedQuery, "login user") {
-		return normalizedQuery, true
+	if _, inner, ok := flattenPublishData(node); ok {
+		node = inner
+	}
+	if cmd, ok := node.(*commandNode); ok && cmd.verb == "login user" {
+		return cmd.source(normalizedQuery), true
 	}
 	return "", false
 }
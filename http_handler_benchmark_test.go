@@ -0,0 +1,96 @@
+package mocka
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// discardResponseWriter is an http.ResponseWriter that drops the body, so
+// benchmarks measure encoding rather than buffering. It counts the bytes
+// written for b.SetBytes.
+type discardResponseWriter struct {
+	header  http.Header
+	status  int
+	written int64
+}
+
+func (w *discardResponseWriter) Header() http.Header    { return w.header }
+func (w *discardResponseWriter) WriteHeader(status int) { w.status = status }
+
+func (w *discardResponseWriter) Write(b []byte) (int, error) {
+	w.written += int64(len(b))
+	return io.Discard.Write(b)
+}
+
+func benchmarkSpec(rows int) SyntheticSpec {
+	return SyntheticSpec{
+		Seed: 1,
+		Rows: rows,
+		Columns: []SyntheticColumn{
+			{Name: "lodnum", Generator: "sequence", Prefix: "LOD"},
+			{Name: "wh_id", Generator: "enum", Values: []string{"MHE", "WMD"}},
+			{Name: "prtnum", Generator: "pattern", Pattern: "PRT-####"},
+			{Name: "lngdsc", Generator: "text", NullRatio: 0.1},
+			{Name: "untqty", Generator: "number", Min: 1, Max: 500},
+			{Name: "adddte", Generator: "date"},
+		},
+	}
+}
+
+func benchmarkHandler(b *testing.B, rows int) *http.ServeMux {
+	b.Helper()
+	rb, err := NewResponse(StatusOK).WithSyntheticResultSet(benchmarkSpec(rows))
+	if err != nil {
+		b.Fatal(err)
+	}
+	lookup, err := NewResponseLookup(NewInMemoryResponseLoader(
+		WithValidation(ValidationOff),
+		WithExactMatch("list inventory", rb.Build()),
+	))
+	if err != nil {
+		b.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	RegisterRoutes(mux, NewMocaRequestHandler(lookup, WithSessionMode(SessionModeDisabled)))
+	return mux
+}
+
+func BenchmarkHandleMocaRequest_LargeResultSet(b *testing.B) {
+	for _, rows := range []int{1_000, 10_000, 100_000} {
+		b.Run(fmt.Sprintf("rows=%d", rows), func(b *testing.B) {
+			mux := benchmarkHandler(b, rows)
+			size := &discardResponseWriter{header: make(http.Header)}
+			mux.ServeHTTP(size, buildRequest(b, "list inventory"))
+			b.SetBytes(size.written)
+			b.ReportAllocs()
+			for b.Loop() {
+				w := &discardResponseWriter{header: make(http.Header)}
+				mux.ServeHTTP(w, buildRequest(b, "list inventory"))
+				if w.status != 0 {
+					b.Fatalf("unexpected status %d", w.status)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkWriteResponse_Uncached measures a response whose result set is
+// parsed on every write, for comparison with the cached path above.
+func BenchmarkWriteResponse_Uncached(b *testing.B) {
+	rb, err := NewResponse(StatusOK).WithSyntheticResultSet(benchmarkSpec(100_000))
+	if err != nil {
+		b.Fatal(err)
+	}
+	response := rb.Build()
+	h := NewMocaRequestHandler(nil)
+	size := &discardResponseWriter{header: make(http.Header)}
+	h.writeResponse(&mocaResponseWriter{ResponseWriter: size, encoder: XMLEncoder{}}, response)
+	b.SetBytes(size.written)
+	b.ReportAllocs()
+	for b.Loop() {
+		w := &mocaResponseWriter{ResponseWriter: &discardResponseWriter{header: make(http.Header)}, encoder: XMLEncoder{}}
+		h.writeResponse(w, response)
+	}
+}
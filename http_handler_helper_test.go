@@ -5,6 +5,7 @@ import (
 	"encoding/xml"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
@@ -20,7 +21,7 @@ func WithSessionKey(key string) TestRequestOption {
 	}
 }
 
-func buildRequest(t *testing.T, command string, options ...TestRequestOption) *http.Request {
+func buildRequest(t testing.TB, command string, options ...TestRequestOption) *http.Request {
 	t.Helper()
 	request := &mocaprotocol.MocaRequest{
 		Autocommit: "true",
@@ -41,6 +42,18 @@ func buildRequest(t *testing.T, command string, options ...TestRequestOption) *h
 	return req
 }
 
+// sendRequest serves command through mux and returns the decoded MOCA response.
+func sendRequest(t *testing.T, mux *http.ServeMux, command string, options ...TestRequestOption) mocaprotocol.MocaResponse {
+	t.Helper()
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, buildRequest(t, command, options...))
+	var response mocaprotocol.MocaResponse
+	if err := xml.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("sendRequest: decoding response: %v", err)
+	}
+	return response
+}
+
 // creteEmptyResponseFiles creates an empty responses.yml in a temporary directory and returns the directory path.
 func creteEmptyResponseFiles(t *testing.T) string {
 	t.Helper()
@@ -2,6 +2,7 @@ package mocka
 
 import (
 	"encoding/xml"
+	"errors"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
@@ -98,6 +99,24 @@ func TestHandleMocaRequest_Login(t *testing.T) {
 			})
 		})
 
+		Convey("When I attempt to login with a double-quoted password containing an apostrophe", func() {
+
+			req := buildRequest(t, `login user where usr_id = "anyuser" and usr_pswd = "it's"`)
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			Convey("Then the response should be OK with a session key", func() {
+				var response mocaprotocol.MocaResponse
+				err := xml.Unmarshal(w.Body.Bytes(), &response)
+				So(err, ShouldBeNil)
+				So(response.Status, ShouldEqual, 0)
+				key := response.MocaResults.Data.Rows[0].Fields[4].Value
+				userID, ok := handler.Sessions().Get(key)
+				So(ok, ShouldBeTrue)
+				So(userID, ShouldEqual, "anyuser")
+			})
+		})
+
 		Convey("When I attempt to login wrapped in a publish data block", func() {
 
 			req := buildRequest(t, "publish data | { login user where usr_id = 'anyuser' and usr_pswd = 'anypass' }")
@@ -655,6 +674,12 @@ func TestLoginInnerQuery(t *testing.T) {
 				So(inner, ShouldEqual, "login user where usr_id = 'anyuser' and usr_pswd = 'anypass'")
 			})
 
+			Convey("with a double-quoted password containing an apostrophe", func() {
+				inner, ok := loginInnerQuery(`login user where usr_id = "anyuser" and usr_pswd = "it's"`)
+				So(ok, ShouldBeTrue)
+				So(inner, ShouldEqual, "login user where usr_id = 'anyuser' and usr_pswd = 'it''s'")
+			})
+
 			Convey("with no where clause on the inner login user", func() {
 				inner, ok := loginInnerQuery("publish data | { login user }")
 				So(ok, ShouldBeTrue)
@@ -766,33 +791,401 @@ func TestIsLoginCommand(t *testing.T) {
 	})
 }
 
-func TestHandleMocaRequest_InvalidResultSetXML(t *testing.T) {
+func TestNewResponseLookup_InvalidResultSetXML(t *testing.T) {
 
-	sessionKey := uuid.NewString()
+	Convey("Given a loader with a response containing invalid result XML", t, func() {
+
+		loader := NewInMemoryResponseLoader(
+			WithExactMatch("list warehouses", NewResponse(StatusOK).Build()),
+			WithExactMatch("get data", NewResponse(StatusOK).WithResultSet("<moca-results><data></moca-results>").Build()),
+		)
+
+		Convey("When I build the lookup", func() {
+
+			_, err := NewResponseLookup(loader)
+
+			Convey("Then construction fails naming the entry", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldStartWith, `entry 2 (exact "get data"): parsing result set: `)
+			})
+		})
+	})
+}
+
+func TestHandleMocaRequest_LazyResultSetError(t *testing.T) {
+
+	Convey("Given a response whose result set fails to generate on first use", t, func() {
+
+		lookup, err := NewResponseLookup(NewInMemoryResponseLoader(
+			WithExactMatch("get data", Response{
+				StatusCode: StatusOK,
+				lazy:       &lazyResultSet{generate: func() (string, error) { return "", errors.New("boom") }},
+			}),
+		))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mux := http.NewServeMux()
+		RegisterRoutes(mux, NewMocaRequestHandler(lookup, WithSessionMode(SessionModeDisabled)))
+
+		Convey("When I run the command", func() {
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, buildRequest(t, "get data"))
+
+			Convey("Then the response should be 500 Internal Server Error", func() {
+				So(w.Code, ShouldEqual, http.StatusInternalServerError)
+			})
+		})
+	})
+}
+
+func TestHandleMocaRequest_SessionKeyGenerator(t *testing.T) {
 
-	Convey("Given I have a MocaRequestHandler with a response containing invalid result XML", t, func() {
+	Convey("Given I have a MocaRequestHandler with a sequential session key generator", t, func() {
+
+		lookup, _ := NewResponseLookup(NewInMemoryResponseLoader())
+		handler := NewMocaRequestHandler(lookup, WithSessionKeyGenerator(SequentialSessionKeys("key-")))
+		mux := http.NewServeMux()
+		RegisterRoutes(mux, handler)
+
+		Convey("When I login twice", func() {
+
+			var keys []string
+			for range 2 {
+				req := buildRequest(t, "login user where usr_id = 'anyuser' and usr_pswd = 'anypass'")
+				w := httptest.NewRecorder()
+				mux.ServeHTTP(w, req)
+				var response mocaprotocol.MocaResponse
+				if err := xml.Unmarshal(w.Body.Bytes(), &response); err != nil {
+					t.Fatal(err)
+				}
+				keys = append(keys, response.MocaResults.Data.Rows[0].Fields[4].Value)
+			}
+
+			Convey("Then the session keys are issued in sequence", func() {
+				So(keys, ShouldResemble, []string{"key-1", "key-2"})
+			})
+
+			Convey("Then both sessions are registered", func() {
+				_, ok1 := handler.Sessions().Get("key-1")
+				_, ok2 := handler.Sessions().Get("key-2")
+				So(ok1, ShouldBeTrue)
+				So(ok2, ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("SeededSessionKeys", t, func() {
+
+		Convey("produces the same sequence for the same seed", func() {
+			a, b := SeededSessionKeys(42), SeededSessionKeys(42)
+			So(a(), ShouldEqual, b())
+			So(a(), ShouldEqual, b())
+		})
+
+		Convey("produces different sequences for different seeds", func() {
+			So(SeededSessionKeys(1)(), ShouldNotEqual, SeededSessionKeys(2)())
+		})
+	})
+}
+
+func TestHandleMocaRequest_PreProvisionedSession(t *testing.T) {
+
+	Convey("Given I have a MocaRequestHandler with a pre-provisioned session", t, func() {
 
 		loader := NewInMemoryResponseLoader(
-			WithExactMatch("get data", NewResponse(StatusOK).WithResultSet("not valid xml").Build()),
+			WithExactMatch("list warehouses", NewResponse(StatusOK).Build()),
 		)
 		lookup, err := NewResponseLookup(loader)
 		if err != nil {
 			t.Fatal(err)
 		}
-		handler := NewMocaRequestHandler(lookup)
-		handler.sessions.Add(sessionKey, "super")
+		handler := NewMocaRequestHandler(lookup, WithSession("known-key", "super"))
 		mux := http.NewServeMux()
 		RegisterRoutes(mux, handler)
 
-		Convey("When I run the command", func() {
+		Convey("When I run a command with that session key and no prior login", func() {
 
-			req := buildRequest(t, "get data", WithSessionKey(sessionKey))
+			req := buildRequest(t, "list warehouses", WithSessionKey("known-key"))
 			w := httptest.NewRecorder()
 			mux.ServeHTTP(w, req)
 
-			Convey("Then the response should be 500 Internal Server Error", func() {
-				So(w.Code, ShouldEqual, http.StatusInternalServerError)
+			Convey("Then the command is accepted", func() {
+				var response mocaprotocol.MocaResponse
+				err := xml.Unmarshal(w.Body.Bytes(), &response)
+				So(err, ShouldBeNil)
+				So(response.Status, ShouldEqual, StatusOK)
+			})
+		})
+	})
+}
+
+func TestHandleMocaRequest_SessionModes(t *testing.T) {
+
+	loader := NewInMemoryResponseLoader(
+		WithExactMatch("list warehouses", NewResponse(StatusOK).Build()),
+		WithExactMatch("list secrets", NewResponse(StatusOK).RequireSession().Build()),
+	)
+
+	newMux := func(mode SessionMode) (*http.ServeMux, *MocaRequestHandler) {
+		lookup, err := NewResponseLookup(loader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler := NewMocaRequestHandler(lookup, WithSessionMode(mode))
+		mux := http.NewServeMux()
+		RegisterRoutes(mux, handler)
+		return mux, handler
+	}
+
+	Convey("Given a handler with session checks disabled", t, func() {
+		mux, _ := newMux(SessionModeDisabled)
+
+		Convey("Then a command without a session key is accepted", func() {
+			So(sendRequest(t, mux, "list warehouses").Status, ShouldEqual, StatusOK)
+		})
+
+		Convey("Then logout with an unknown session key succeeds", func() {
+			So(sendRequest(t, mux, "logout user", WithSessionKey("unknown")).Status, ShouldEqual, StatusOK)
+		})
+	})
+
+	Convey("Given a handler that auto-creates sessions", t, func() {
+		mux, handler := newMux(SessionModeAutoCreate)
+
+		Convey("When a command carries an unknown session key", func() {
+			response := sendRequest(t, mux, "list warehouses", WithSessionKey("made-up"))
+
+			Convey("Then it is accepted and the session is registered", func() {
+				So(response.Status, ShouldEqual, StatusOK)
+				_, ok := handler.Sessions().Get("made-up")
+				So(ok, ShouldBeTrue)
+			})
+		})
+
+		Convey("When a command carries no session key", func() {
+			response := sendRequest(t, mux, "list warehouses")
+
+			Convey("Then it is rejected", func() {
+				So(response.Status, ShouldEqual, StatusInvalidSessionKey)
+			})
+		})
+	})
+
+	Convey("Given a handler that requires sessions only for marked entries", t, func() {
+		mux, _ := newMux(SessionModeEntry)
+
+		Convey("Then an unmarked entry is served without a session key", func() {
+			So(sendRequest(t, mux, "list warehouses").Status, ShouldEqual, StatusOK)
+		})
+
+		Convey("Then a marked entry is rejected without a session key", func() {
+			So(sendRequest(t, mux, "list secrets").Status, ShouldEqual, StatusInvalidSessionKey)
+		})
+
+		Convey("Then a marked entry is served with a valid session key", func() {
+			login := sendRequest(t, mux, "login user where usr_id = 'u' and usr_pswd = 'p'")
+			key := login.MocaResults.Data.Rows[0].Fields[4].Value
+			So(sendRequest(t, mux, "list secrets", WithSessionKey(key)).Status, ShouldEqual, StatusOK)
+		})
+
+		Convey("Then an unmatched command returns command not found without a session key", func() {
+			So(sendRequest(t, mux, "list nothing").Status, ShouldEqual, StatusCommandNotFound)
+		})
+	})
+
+	Convey("ParseSessionMode", t, func() {
+
+		Convey("accepts every defined mode", func() {
+			for _, s := range []string{"strict", "disabled", "auto", "entry"} {
+				mode, err := ParseSessionMode(s)
+				So(err, ShouldBeNil)
+				So(string(mode), ShouldEqual, s)
+			}
+		})
+
+		Convey("rejects an unknown mode", func() {
+			_, err := ParseSessionMode("lenient")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestHandleMocaRequest_BuiltinOverrides(t *testing.T) {
+
+	newHandler := func(opts ...InMemoryResponseLoaderOption) (*http.ServeMux, *MocaRequestHandler) {
+		lookup, err := NewResponseLookup(NewInMemoryResponseLoader(opts...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler := NewMocaRequestHandler(lookup, WithSessionKeyGenerator(SequentialSessionKeys("key-")))
+		mux := http.NewServeMux()
+		RegisterRoutes(mux, handler)
+		return mux, handler
+	}
+
+	Convey("Given a ping override that fails", t, func() {
+		mux, _ := newHandler(
+			WithBuiltinOverride(WithExactMatch("ping", NewResponse(StatusDBError).WithMessage("down").Build())),
+		)
+
+		Convey("Then ping returns the override", func() {
+			response := sendRequest(t, mux, "ping")
+			So(response.Status, ShouldEqual, StatusDBError)
+			So(response.Message, ShouldEqual, "down")
+		})
+	})
+
+	Convey("Given a login override for a locked user", t, func() {
+		mux, handler := newHandler(
+			WithBuiltinOverride(WithPrefixMatch("login user where usr_id = 'locked'",
+				NewResponse(StatusInvalidSessionKey).WithMessage("Account locked").Build())),
+		)
+
+		Convey("When the locked user logs in", func() {
+			response := sendRequest(t, mux, "login user where usr_id = 'LOCKED' and usr_pswd = 'x'")
+
+			Convey("Then the override is returned and no session is created", func() {
+				So(response.Status, ShouldEqual, StatusInvalidSessionKey)
+				So(response.Message, ShouldEqual, "Account locked")
+				So(handler.Sessions().Len(), ShouldEqual, 0)
 			})
 		})
+
+		Convey("When another user logs in", func() {
+			response := sendRequest(t, mux, "login user where usr_id = 'other' and usr_pswd = 'x'")
+
+			Convey("Then the default login is used", func() {
+				So(response.Status, ShouldEqual, StatusOK)
+				So(response.MocaResults.Data.Rows[0].Fields[4].Value, ShouldEqual, "key-1")
+			})
+		})
+	})
+
+	Convey("Given a successful login override with no result set", t, func() {
+		mux, handler := newHandler(
+			WithBuiltinOverride(WithPrefixMatch("login user", NewResponse(StatusOK).Build())),
+		)
+
+		Convey("When a user logs in without a password", func() {
+			response := sendRequest(t, mux, "login user where usr_id = 'anyuser'")
+
+			Convey("Then the standard login result set is returned and a session is created", func() {
+				So(response.Status, ShouldEqual, StatusOK)
+				So(response.MocaResults.Data.Rows[0].Fields[4].Value, ShouldEqual, "key-1")
+				user, ok := handler.Sessions().Get("key-1")
+				So(ok, ShouldBeTrue)
+				So(user, ShouldEqual, "anyuser")
+			})
+		})
+	})
+
+	Convey("Given a successful login override with a session_key column", t, func() {
+		mux, handler := newHandler(
+			WithBuiltinOverride(WithPrefixMatch("login user", NewResponse(StatusOK).WithResultSet(`<moca-results>
+				<metadata><column name="session_key" type="S" length="0" nullable="true"/></metadata>
+				<data><row><field>fixed-key</field></row></data>
+			</moca-results>`).Build())),
+		)
+
+		Convey("When a user logs in", func() {
+			response := sendRequest(t, mux, "login user where usr_id = 'anyuser' and usr_pswd = 'x'")
+
+			Convey("Then the session is registered under the override's key", func() {
+				So(response.Status, ShouldEqual, StatusOK)
+				So(response.MocaResults.Data.Rows[0].Fields[0].Value, ShouldEqual, "fixed-key")
+				_, ok := handler.Sessions().Get("fixed-key")
+				So(ok, ShouldBeTrue)
+			})
+		})
+	})
+
+	Convey("Given logout overrides", t, func() {
+
+		Convey("When the override fails", func() {
+			mux, handler := newHandler(
+				WithBuiltinOverride(WithExactMatch("logout user", NewResponse(StatusDBError).WithMessage("nope").Build())),
+			)
+			handler.Sessions().Add("k", "super")
+			response := sendRequest(t, mux, "logout user", WithSessionKey("k"))
+
+			Convey("Then the error is returned and the session is kept", func() {
+				So(response.Status, ShouldEqual, StatusDBError)
+				So(handler.Sessions().Len(), ShouldEqual, 1)
+			})
+		})
+
+		Convey("When the override succeeds", func() {
+			mux, handler := newHandler(
+				WithBuiltinOverride(WithExactMatch("logout user", NewResponse(StatusOK).WithMessage("bye").Build())),
+			)
+			handler.Sessions().Add("k", "super")
+			response := sendRequest(t, mux, "logout user", WithSessionKey("k"))
+
+			Convey("Then the override is returned and the session is removed", func() {
+				So(response.Status, ShouldEqual, StatusOK)
+				So(response.Message, ShouldEqual, "bye")
+				So(handler.Sessions().Len(), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the override succeeds for an unknown session key", func() {
+			mux, handler := newHandler(
+				WithBuiltinOverride(WithExactMatch("logout user", NewResponse(StatusOK).WithMessage("bye").Build())),
+			)
+			handler.Sessions().Add("k", "super")
+			response := sendRequest(t, mux, "logout user", WithSessionKey("other"))
+
+			Convey("Then the request is rejected and no session is removed", func() {
+				So(response.Status, ShouldEqual, StatusInvalidSessionKey)
+				So(handler.Sessions().Len(), ShouldEqual, 1)
+			})
+		})
+	})
+
+	Convey("Given a builtin override whose query is not a built-in command", t, func() {
+		mux, handler := newHandler(
+			WithBuiltinOverride(WithExactMatch("list warehouses", NewResponse(StatusOK).Build())),
+		)
+		handler.Sessions().Add("k", "super")
+
+		Convey("Then it is not used for ordinary queries", func() {
+			So(sendRequest(t, mux, "list warehouses", WithSessionKey("k")).Status, ShouldEqual, StatusCommandNotFound)
+		})
+	})
+}
+
+func TestHandleMocaRequest_LiteralNormalization(t *testing.T) {
+
+	Convey("Given literal and lossy entries for the same command", t, func() {
+
+		lookup, err := NewResponseLookup(NewInMemoryResponseLoader(
+			WithNormalizedMatch(NormalizationLiteral, WithExactMatch("list items where descr = 'A=B'", NewResponse(StatusOK).WithMessage("literal").Build())),
+			WithExactMatch("list items where descr = 'x'", NewResponse(StatusOK).WithMessage("lossy").Build()),
+		))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mux := http.NewServeMux()
+		RegisterRoutes(mux, NewMocaRequestHandler(lookup, WithSessionMode(SessionModeDisabled)))
+		send := func(query string) mocaprotocol.MocaResponse {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, buildRequest(t, query))
+			return decodeBody(t, w)
+		}
+
+		Convey("Then the literal entry sees the query's literal contents", func() {
+			So(send("LIST ITEMS WHERE descr = \"A=B\"").Message, ShouldEqual, "literal")
+			So(send("list items where descr = 'a = b'").Status, ShouldEqual, StatusCommandNotFound)
+		})
+
+		Convey("Then a miss reports the lossy form of the query", func() {
+			So(send("LIST ITEMS WHERE descr = 'Nope'").Message, ShouldEqual, "Command (list items where descr = 'nope') not found")
+		})
+
+		Convey("Then lossy entries still ignore literal case", func() {
+			So(send("list items where descr = 'X'").Message, ShouldEqual, "lossy")
+		})
 	})
 }
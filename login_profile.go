@@ -0,0 +1,206 @@
+package mocka
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// LoginProfile holds the values mocka returns in the login result set.
+// Empty fields fall back to the handler-wide profile, and from there to
+// DefaultLoginProfile.
+type LoginProfile struct {
+	LocaleID string `yaml:"locale_id,omitempty"`
+	AddonID  string `yaml:"addon_id,omitempty"`
+	CustLvl  string `yaml:"cust_lvl,omitempty"`
+	SrvTyp   string `yaml:"srv_typ,omitempty"`
+	// Columns are appended to the standard login columns. A column with the
+	// same name as one in the handler-wide profile replaces it. Names must
+	// not repeat a standard login column or each other; see Validate.
+	Columns []LoginColumn `yaml:"columns,omitempty"`
+}
+
+// standardLoginColumns are the columns of every login result set.
+var standardLoginColumns = map[string]bool{
+	"usr_id": true, "locale_id": true, "addon_id": true, "cust_lvl": true,
+	"session_key": true, "pswd_expir": true, "pswd_expir_dte": true,
+	"pswd_disable": true, "pswd_chg_flg": true, "pswd_expir_flg": true,
+	"pswd_warn_flg": true, "srv_typ": true, "super_usr_flg": true, "ext_ath_flg": true,
+}
+
+// LoginColumn is an extra column returned in the login result set.
+type LoginColumn struct {
+	Name  string `yaml:"name"`
+	Type  string `yaml:"type"` // MOCA column type, e.g. S or I; defaults to S
+	Value string `yaml:"value"`
+}
+
+// Validate reports the invalid extra columns of p: columns without a name,
+// named like a standard login column or an earlier extra column, or with an
+// unknown type code.
+func (p LoginProfile) Validate() error {
+	_, err := checkLoginColumns(p.Columns)
+	return err
+}
+
+// checkLoginColumns returns the valid columns of cols, with type codes
+// uppercased, and an error describing the rest.
+func checkLoginColumns(cols []LoginColumn) ([]LoginColumn, error) {
+	var valid []LoginColumn
+	var errs []error
+	seen := make(map[string]bool)
+	for _, c := range cols {
+		name := strings.ToLower(strings.TrimSpace(c.Name))
+		switch {
+		case name == "":
+			errs = append(errs, errors.New("login column without a name"))
+			continue
+		case standardLoginColumns[name]:
+			errs = append(errs, fmt.Errorf("login column %q duplicates a standard login column", c.Name))
+			continue
+		case seen[name]:
+			errs = append(errs, fmt.Errorf("login column %q is repeated", c.Name))
+			continue
+		}
+		if c.Type != "" {
+			typ, err := parseColumnType(c.Type)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("login column %q: %w", c.Name, err))
+				continue
+			}
+			c.Type = string(typ)
+		}
+		seen[name] = true
+		valid = append(valid, c)
+	}
+	return valid, errors.Join(errs...)
+}
+
+// DefaultLoginProfile returns the values mocka has always returned at login.
+func DefaultLoginProfile() LoginProfile {
+	return LoginProfile{
+		LocaleID: "US_ENGLISH",
+		AddonID:  "WM,lm,SEAMLES,SEAMLES,3pl",
+		CustLvl:  "10",
+		SrvTyp:   "DEVELOPMENT",
+	}
+}
+
+// merge returns p with empty fields taken from base. Columns in p replace
+// same-named columns in base, ignoring case, and are otherwise appended.
+func (p LoginProfile) merge(base LoginProfile) LoginProfile {
+	out := base
+	if p.LocaleID != "" {
+		out.LocaleID = p.LocaleID
+	}
+	if p.AddonID != "" {
+		out.AddonID = p.AddonID
+	}
+	if p.CustLvl != "" {
+		out.CustLvl = p.CustLvl
+	}
+	if p.SrvTyp != "" {
+		out.SrvTyp = p.SrvTyp
+	}
+	out.Columns = append([]LoginColumn(nil), base.Columns...)
+	for _, c := range p.Columns {
+		replaced := false
+		for i := range out.Columns {
+			if strings.EqualFold(strings.TrimSpace(out.Columns[i].Name), strings.TrimSpace(c.Name)) {
+				out.Columns[i] = c
+				replaced = true
+			}
+		}
+		if !replaced {
+			out.Columns = append(out.Columns, c)
+		}
+	}
+	return out
+}
+
+// WithLoginProfile sets the login result set values for every user. Empty
+// fields keep their DefaultLoginProfile values. Columns that fail Validate
+// are dropped with a warning.
+func WithLoginProfile(p LoginProfile) MocaRequestHandlerOption {
+	return func(h *MocaRequestHandler) {
+		h.login = h.validLoginProfile(p).merge(DefaultLoginProfile())
+	}
+}
+
+// WithUserLoginProfile sets the login result set values for userID
+// (case-insensitive). Empty fields fall back to the handler-wide profile.
+// Columns that fail Validate are dropped with a warning.
+func WithUserLoginProfile(userID string, p LoginProfile) MocaRequestHandlerOption {
+	return func(h *MocaRequestHandler) {
+		h.userLogins[strings.ToLower(userID)] = h.validLoginProfile(p)
+	}
+}
+
+// validLoginProfile returns p without its invalid columns.
+func (h *MocaRequestHandler) validLoginProfile(p LoginProfile) LoginProfile {
+	var err error
+	p.Columns, err = checkLoginColumns(p.Columns)
+	if err != nil {
+		h.logger.Warn("dropping invalid login columns", "error", err)
+	}
+	return p
+}
+
+// loginConfig is the YAML layout read by LoadLoginProfiles.
+type loginConfig struct {
+	Defaults LoginProfile            `yaml:"defaults"`
+	Users    map[string]LoginProfile `yaml:"users"`
+}
+
+// LoadLoginProfiles reads a YAML file of login profiles and returns the
+// handler options that apply them. The file has the form:
+//
+//	defaults:
+//	  srv_typ: PRODUCTION
+//	users:
+//	  SUPER:
+//	    addon_id: WM
+//	    columns:
+//	      - name: usr_role
+//	        value: ADMIN
+func LoadLoginProfiles(path string) ([]MocaRequestHandlerOption, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	var cfg loginConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := cfg.Defaults.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: defaults: %w", path, err)
+	}
+	opts := []MocaRequestHandlerOption{WithLoginProfile(cfg.Defaults)}
+	seen := make(map[string]string)
+	for _, userID := range slices.Sorted(maps.Keys(cfg.Users)) {
+		p := cfg.Users[userID]
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: users %s: %w", path, userID, err)
+		}
+		// User IDs are case-insensitive, so SUPER and super are one user.
+		if other, ok := seen[strings.ToLower(userID)]; ok {
+			return nil, fmt.Errorf("%s: users %s and %s differ only in case", path, other, userID)
+		}
+		seen[strings.ToLower(userID)] = userID
+		opts = append(opts, WithUserLoginProfile(userID, p))
+	}
+	return opts, nil
+}
+
+// loginProfileFor returns the effective login profile for userID.
+func (h *MocaRequestHandler) loginProfileFor(userID string) LoginProfile {
+	if p, ok := h.userLogins[strings.ToLower(userID)]; ok {
+		return p.merge(h.login)
+	}
+	return h.login
+}
@@ -0,0 +1,182 @@
+package mocka
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHandleMocaRequest_LoginProfiles(t *testing.T) {
+
+	newMux := func(t *testing.T, opts ...MocaRequestHandlerOption) *http.ServeMux {
+		lookup, err := NewResponseLookup(NewInMemoryResponseLoader())
+		if err != nil {
+			t.Fatal(err)
+		}
+		mux := http.NewServeMux()
+		RegisterRoutes(mux, NewMocaRequestHandler(lookup, opts...))
+		return mux
+	}
+
+	Convey("Given a handler with no login profile", t, func() {
+		mux := newMux(t)
+
+		Convey("Then login returns the default values", func() {
+			row := sendRequest(t, mux, "login user where usr_id = 'u' and usr_pswd = 'p'").MocaResults.Data.Rows[0]
+			So(row.Fields[1].Value, ShouldEqual, "US_ENGLISH")
+			So(row.Fields[2].Value, ShouldEqual, "WM,lm,SEAMLES,SEAMLES,3pl")
+			So(row.Fields[3].Value, ShouldEqual, "10")
+			So(row.Fields[11].Value, ShouldEqual, "DEVELOPMENT")
+		})
+	})
+
+	Convey("Given a handler-wide profile and a per-user profile", t, func() {
+		mux := newMux(t,
+			WithLoginProfile(LoginProfile{
+				SrvTyp:  "PRODUCTION",
+				Columns: []LoginColumn{{Name: "usr_role", Value: "USER"}},
+			}),
+			WithUserLoginProfile("SUPER", LoginProfile{
+				AddonID: "WM",
+				Columns: []LoginColumn{{Name: "usr_role", Value: "ADMIN"}, {Name: "max_qty", Type: "I", Value: "5"}},
+			}),
+		)
+
+		Convey("When an ordinary user logs in", func() {
+			response := sendRequest(t, mux, "login user where usr_id = 'u' and usr_pswd = 'p'")
+			row := response.MocaResults.Data.Rows[0]
+
+			Convey("Then the handler-wide values are used and unset fields keep their defaults", func() {
+				So(row.Fields[11].Value, ShouldEqual, "PRODUCTION")
+				So(row.Fields[1].Value, ShouldEqual, "US_ENGLISH")
+				So(response.MocaResults.Metadata.Columns, ShouldHaveLength, 15)
+				So(response.MocaResults.Metadata.Columns[14].Name, ShouldEqual, "usr_role")
+				So(row.Fields[14].Value, ShouldEqual, "USER")
+			})
+		})
+
+		Convey("When the profiled user logs in", func() {
+			response := sendRequest(t, mux, "login user where usr_id = 'super' and usr_pswd = 'p'")
+			row := response.MocaResults.Data.Rows[0]
+
+			Convey("Then the per-user values override the handler-wide ones", func() {
+				So(row.Fields[2].Value, ShouldEqual, "WM")
+				So(row.Fields[11].Value, ShouldEqual, "PRODUCTION")
+				So(response.MocaResults.Metadata.Columns, ShouldHaveLength, 16)
+				So(row.Fields[14].Value, ShouldEqual, "ADMIN")
+				So(response.MocaResults.Metadata.Columns[15].Name, ShouldEqual, "max_qty")
+				So(response.MocaResults.Metadata.Columns[15].Type, ShouldEqual, "I")
+				So(row.Fields[15].Value, ShouldEqual, "5")
+			})
+		})
+	})
+}
+
+func TestLoadLoginProfiles(t *testing.T) {
+
+	Convey("LoadLoginProfiles", t, func() {
+
+		Convey("Given a valid profile file", func() {
+			dir := t.TempDir()
+			writeTestFile(t, dir, "login.yml", `
+defaults:
+  srv_typ: PRODUCTION
+users:
+  SUPER:
+    locale_id: FRENCH
+`)
+			opts, err := LoadLoginProfiles(filepath.Join(dir, "login.yml"))
+			So(err, ShouldBeNil)
+
+			lookup, _ := NewResponseLookup(NewInMemoryResponseLoader())
+			handler := NewMocaRequestHandler(lookup, opts...)
+
+			Convey("Then the defaults and per-user values are applied", func() {
+				So(handler.loginProfileFor("other").SrvTyp, ShouldEqual, "PRODUCTION")
+				So(handler.loginProfileFor("super").LocaleID, ShouldEqual, "FRENCH")
+				So(handler.loginProfileFor("super").SrvTyp, ShouldEqual, "PRODUCTION")
+			})
+		})
+
+		Convey("Given a profile with invalid extra columns", func() {
+			dir := t.TempDir()
+			writeTestFile(t, dir, "login.yml", `
+users:
+  SUPER:
+    columns:
+      - name: SESSION_KEY
+        value: fixed
+      - name: usr_role
+        type: Q
+        value: ADMIN
+`)
+			_, err := LoadLoginProfiles(filepath.Join(dir, "login.yml"))
+
+			Convey("Then the duplicate name and the unknown type are both reported", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "users SUPER")
+				So(err.Error(), ShouldContainSubstring, `"SESSION_KEY" duplicates a standard login column`)
+				So(err.Error(), ShouldContainSubstring, `unknown column type "Q"`)
+			})
+		})
+
+		Convey("Given user IDs that differ only in case", func() {
+			dir := t.TempDir()
+			writeTestFile(t, dir, "login.yml", `
+users:
+  SUPER:
+    locale_id: FRENCH
+  super:
+    locale_id: GERMAN
+`)
+			_, err := LoadLoginProfiles(filepath.Join(dir, "login.yml"))
+
+			Convey("Then both are reported", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "users SUPER and super differ only in case")
+			})
+		})
+
+		Convey("Given a missing file", func() {
+			_, err := LoadLoginProfiles("/no/such/login.yml")
+
+			Convey("Then an error naming the file is returned", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "/no/such/login.yml")
+			})
+		})
+	})
+}
+
+func TestLoginProfile_Validate(t *testing.T) {
+
+	Convey("Given extra login columns", t, func() {
+		p := LoginProfile{Columns: []LoginColumn{
+			{Name: "usr_role", Type: "s", Value: "ADMIN"},
+			{Name: "USR_ROLE", Value: "OTHER"},
+			{Name: "usr_id", Value: "x"},
+		}}
+
+		Convey("Then repeated and standard names are rejected", func() {
+			So(p.Validate(), ShouldNotBeNil)
+			So(LoginProfile{Columns: p.Columns[:1]}.Validate(), ShouldBeNil)
+		})
+
+		Convey("Then the Go options drop them and uppercase type codes", func() {
+			lookup, _ := NewResponseLookup(NewInMemoryResponseLoader())
+			handler := NewMocaRequestHandler(lookup, WithLoginProfile(p))
+			So(handler.loginProfileFor("any").Columns, ShouldResemble, []LoginColumn{{Name: "usr_role", Type: "S", Value: "ADMIN"}})
+		})
+
+		Convey("Then a user column replaces a default one named in another case", func() {
+			lookup, _ := NewResponseLookup(NewInMemoryResponseLoader())
+			handler := NewMocaRequestHandler(lookup,
+				WithLoginProfile(LoginProfile{Columns: p.Columns[:1]}),
+				WithUserLoginProfile("super", LoginProfile{Columns: p.Columns[1:2]}),
+			)
+			So(handler.loginProfileFor("SUPER").Columns, ShouldResemble, []LoginColumn{{Name: "USR_ROLE", Value: "OTHER"}})
+		})
+	})
+}
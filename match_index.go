@@ -0,0 +1,215 @@
+package mocka
+
+import "log/slog"
+
+// Matching hierarchy steps, in order, as ranked by matchIndex.
+const (
+	stepExact = iota + 1
+	stepPublishDataContextual
+	stepPublishDataGeneric
+	stepSQL
+	stepGroovy
+	stepPrefix
+)
+
+var stepNames = map[int]string{
+	stepExact:                 "exact match",
+	stepPublishDataContextual: "publish_data contextual match",
+	stepPublishDataGeneric:    "publish_data generic match",
+	stepSQL:                   "sql match",
+	stepGroovy:                "groovy match",
+	stepPrefix:                "prefix match",
+}
+
+// matchIndex resolves queries against a fixed set of entries with the same
+// result as findMatch, without scanning every entry: exact entries are
+// hashed by query, publish_data entries by inner command and prefix entries
+// are held in a trie, each keyed by normalization mode. sql and groovy
+// entries are still scanned, but only those. Entry positions refer to
+// entries, which is sorted by descending Priority.
+type matchIndex struct {
+	entries  []Entry
+	exact    map[indexKey][]int
+	publish  map[indexKey][]int
+	sql      []int
+	groovy   []int
+	prefixes map[NormalizationMode]*prefixNode
+}
+
+// indexKey is a normalized pattern in the mode it was normalized with.
+type indexKey struct {
+	mode NormalizationMode
+	text string
+}
+
+// prefixNode is a byte trie node; entries holds the positions of the
+// prefix entries whose prefix ends here, in load order.
+type prefixNode struct {
+	children map[byte]*prefixNode
+	entries  []int
+}
+
+// indexMatch is a candidate entry found by matchIndex.
+type indexMatch struct {
+	pos    int // position in entries; -1 for none
+	step   int
+	length int // prefix length, for stepPrefix
+}
+
+// newMatchIndex indexes entries, which must be sorted by descending
+// Priority.
+func newMatchIndex(entries []Entry) *matchIndex {
+	ix := &matchIndex{
+		entries:  entries,
+		exact:    make(map[indexKey][]int),
+		publish:  make(map[indexKey][]int),
+		prefixes: make(map[NormalizationMode]*prefixNode),
+	}
+	for i, e := range entries {
+		mode := indexMode(e.Normalization)
+		switch e.MatchType {
+		case MatchTypeExact:
+			k := indexKey{mode, e.Query}
+			ix.exact[k] = append(ix.exact[k], i)
+		case MatchTypePublishData:
+			k := indexKey{mode, e.Inner}
+			ix.publish[k] = append(ix.publish[k], i)
+		case MatchTypeSQL:
+			ix.sql = append(ix.sql, i)
+		case MatchTypeGroovy:
+			ix.groovy = append(ix.groovy, i)
+		case MatchTypePrefix:
+			node := ix.prefixes[mode]
+			if node == nil {
+				node = &prefixNode{}
+				ix.prefixes[mode] = node
+			}
+			for j := 0; j < len(e.Prefix); j++ {
+				child := node.children[e.Prefix[j]]
+				if child == nil {
+					if node.children == nil {
+						node.children = make(map[byte]*prefixNode)
+					}
+					child = &prefixNode{}
+					node.children[e.Prefix[j]] = child
+				}
+				node = child
+			}
+			node.entries = append(node.entries, i)
+		}
+	}
+	return ix
+}
+
+// indexMode maps the zero NormalizationMode to NormalizationLossy.
+func indexMode(mode NormalizationMode) NormalizationMode {
+	if mode == NormalizationLiteral {
+		return NormalizationLiteral
+	}
+	return NormalizationLossy
+}
+
+// match runs steps 1–5 of the matching hierarchy and reports whether any
+// entry matched. Every step contributes its best candidate and the best of
+// those wins, ranked as findMatch ranks them: by priority, then step, then
+// prefix length, then load order. Candidates that cannot beat the best so
+// far are not evaluated.
+func (ix *matchIndex) match(query string, logger *slog.Logger) (Response, bool) {
+	forms := newQueryForms(query)
+	best := indexMatch{pos: -1}
+	// first sets best to the first entry of positions, in load order, that
+	// passes ok, stopping at the first entry that could not beat best.
+	first := func(positions []int, step, length int, ok func(Entry) bool) {
+		for _, pos := range positions {
+			c := indexMatch{pos, step, length}
+			if !ix.beats(c, best) {
+				return
+			}
+			if ok(ix.entries[pos]) && forms.argsMatch(ix.entries[pos]) {
+				best = c
+				return
+			}
+		}
+	}
+	// settled reports whether best is an exact match with the highest
+	// priority of any entry, so no later step can beat it and the query
+	// need not be parsed. Any other candidate may still lose to one found
+	// in the other normalization mode.
+	settled := func() bool {
+		return best.pos >= 0 && best.step == stepExact && ix.entries[best.pos].Priority == ix.entries[0].Priority
+	}
+	modes := [...]NormalizationMode{NormalizationLossy, NormalizationLiteral}
+
+	// 1. Exact match
+	for _, mode := range modes {
+		first(ix.exact[indexKey{mode, forms.query(mode)}], stepExact, 0, func(Entry) bool { return true })
+	}
+
+	// 2. Publish-data contextual match, then generic fallback
+	for _, mode := range modes {
+		if len(ix.publish) == 0 || settled() {
+			break
+		}
+		pd, ok := forms.publishData(mode)
+		if !ok {
+			continue
+		}
+		positions := ix.publish[indexKey{mode, pd.inner}]
+		first(positions, stepPublishDataContextual, 0, func(e Entry) bool {
+			return len(e.Context) > 0 && contextMatches(e.Context, pd.context)
+		})
+		first(positions, stepPublishDataGeneric, 0, func(e Entry) bool { return len(e.Context) == 0 })
+	}
+
+	// 3. SQL match
+	first(ix.sql, stepSQL, 0, func(e Entry) bool {
+		stmt, ok := forms.sqlStatement(e.Normalization)
+		return ok && sqlMatches(e, stmt)
+	})
+
+	// 4. Groovy match
+	first(ix.groovy, stepGroovy, 0, func(e Entry) bool {
+		script, ok := forms.groovyScript(e.Normalization)
+		return ok && groovyMatches(e, script)
+	})
+
+	// 5. Prefix match, walking every prefix of the query down the trie
+	for _, mode := range modes {
+		if settled() {
+			break
+		}
+		q := forms.query(mode)
+		node := ix.prefixes[mode]
+		for depth := 0; node != nil; depth++ {
+			first(node.entries, stepPrefix, depth, func(Entry) bool { return true })
+			if depth == len(q) {
+				break
+			}
+			node = node.children[q[depth]]
+		}
+	}
+
+	if best.pos < 0 {
+		return Response{}, false
+	}
+	e := ix.entries[best.pos]
+	logger.Debug(stepNames[best.step], "entry", e.describe(), "priority", e.Priority)
+	return e.response(), true
+}
+
+// beats reports whether candidate c ranks ahead of best.
+func (ix *matchIndex) beats(c, best indexMatch) bool {
+	if best.pos < 0 {
+		return true
+	}
+	if pc, pb := ix.entries[c.pos].Priority, ix.entries[best.pos].Priority; pc != pb {
+		return pc > pb
+	}
+	if c.step != best.step {
+		return c.step < best.step
+	}
+	if c.length != best.length {
+		return c.length > best.length
+	}
+	return c.pos < best.pos
+}
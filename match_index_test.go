@@ -0,0 +1,211 @@
+package mocka
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMatchIndex(t *testing.T) {
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	Convey("Given a mixed fixture set", t, func() {
+		resp := func(msg string) Response { return NewResponse(StatusOK).WithMessage(msg).Build() }
+		entries, err := NewInMemoryResponseLoader(
+			WithExactMatch("list warehouses", resp("exact")),
+			WithExactMatch("list warehouses", resp("exact duplicate")),
+			WithNormalizedMatch(NormalizationLiteral, WithExactMatch("list items where descr = 'A=B'", resp("exact literal"))),
+			WithContextualPublishDataMatch("list orders", map[string]string{"wh_id": "WMD1"}, resp("publish contextual")),
+			WithPublishDataMatch("list orders", resp("publish generic")),
+			WithArgs(map[string]ArgPredicate{"qty": ArgGt(100)}, WithPublishDataMatch("list parts", resp("publish args"))),
+			WithSQLMatch("select * from locmst where stoloc = ?", resp("sql")),
+			WithGroovySnippetMatch([]string{"moca.executeCommand('list areas')"}, []*regexp.Regexp{regexp.MustCompile(`size`)}, resp("groovy")),
+			WithPrefixMatch("list", resp("prefix short")),
+			WithPrefixMatch("list locations", resp("prefix long")),
+			WithPrefixMatch("list locations", resp("prefix long duplicate")),
+			WithArgs(map[string]ArgPredicate{"arecod": ArgEq("FAST")}, WithPrefixMatch("list locations where", resp("prefix args"))),
+			WithPriority(5, WithPrefixMatch("publish data where ordtyp = 'R'", resp("priority prefix"))),
+			WithPriority(-1, WithPrefixMatch("", resp("last resort"))),
+			WithNormalizedMatch(NormalizationLiteral, WithPrefixMatch("list parts where descr = 'X", resp("prefix literal"))),
+		).Load()
+		So(err, ShouldBeNil)
+		sorted := sortByPriority(entries)
+		ix := newMatchIndex(sorted)
+
+		queries := []string{
+			"list warehouses",
+			"LIST   Warehouses",
+			"list items where descr = 'A=B'",
+			"list items where descr = 'a=b'",
+			"publish data where wh_id = 'WMD1' | { list orders }",
+			"publish data where wh_id = 'WMD2' | { list orders }",
+			"publish data where ordtyp = 'R' | { list orders }",
+			"publish data where qty = 500 | { list parts }",
+			"publish data where qty = 5 | { list parts }",
+			"[select * from locmst where stoloc = 'A1']",
+			"[[def rows = moca.executeCommand(\"list areas\"); rows.size()]]",
+			"list locations where arecod = 'FAST'",
+			"list locations where arecod = 'SLOW'",
+			"list locations",
+			"list areas",
+			"list parts where descr = 'Xylophone'",
+			"list parts where descr = 'xylophone'",
+			"get something else",
+			"",
+		}
+
+		Convey("Then the index resolves every query as findMatch does", func() {
+			for _, q := range queries {
+				q = normalizeLiteralQuery(q)
+				want, wantOK := findMatch(q, sorted, logger)
+				got, gotOK := ix.match(q, logger)
+				So(gotOK, ShouldEqual, wantOK)
+				So(got.Message, ShouldEqual, want.Message)
+			}
+		})
+
+		Convey("Then the longest prefix wins, ties going to load order", func() {
+			r, _ := ix.match(normalizeLiteralQuery("list locations where x = 1"), logger)
+			So(r.Message, ShouldEqual, "prefix long")
+			r, _ = ix.match(normalizeLiteralQuery("list areas"), logger)
+			So(r.Message, ShouldEqual, "prefix short")
+		})
+
+		Convey("Then a higher priority beats an earlier step", func() {
+			r, _ := ix.match(normalizeLiteralQuery("publish data where ordtyp = 'R' | { list orders }"), logger)
+			So(r.Message, ShouldEqual, "priority prefix")
+		})
+
+		Convey("Then a negative priority is only used when nothing else matches", func() {
+			r, _ := ix.match(normalizeLiteralQuery("get something else"), logger)
+			So(r.Message, ShouldEqual, "last resort")
+			r, _ = ix.match(normalizeLiteralQuery("list warehouses"), logger)
+			So(r.Message, ShouldEqual, "exact")
+		})
+	})
+
+	Convey("Given lossy and literal entries with no priorities", t, func() {
+		resp := func(msg string) Response { return NewResponse(StatusOK).WithMessage(msg).Build() }
+		entries, err := NewInMemoryResponseLoader(
+			WithExactMatch("list warehouses", resp("exact")),
+			WithPublishDataMatch("list orders", resp("publish generic")),
+			WithNormalizedMatch(NormalizationLiteral, WithContextualPublishDataMatch("list orders", map[string]string{"wh_id": "WMD1"}, resp("publish literal contextual"))),
+			WithPrefixMatch("list", resp("prefix short")),
+			WithNormalizedMatch(NormalizationLiteral, WithPrefixMatch("list parts where descr = 'X", resp("prefix literal long"))),
+		).Load()
+		So(err, ShouldBeNil)
+		sorted := sortByPriority(entries)
+		ix := newMatchIndex(sorted)
+		match := func(q string) string {
+			r, _ := ix.match(normalizeLiteralQuery(q), logger)
+			return r.Message
+		}
+
+		Convey("Then the index resolves every query as findMatch does", func() {
+			for _, q := range []string{
+				"list warehouses",
+				"publish data where wh_id = 'WMD1' | { list orders }",
+				"publish data where wh_id = 'wmd1' | { list orders }",
+				"list parts where descr = 'Xylophone'",
+				"list parts where descr = 'xylophone'",
+				"list areas",
+			} {
+				q = normalizeLiteralQuery(q)
+				want, wantOK := findMatch(q, sorted, logger)
+				got, gotOK := ix.match(q, logger)
+				So(gotOK, ShouldEqual, wantOK)
+				So(got.Message, ShouldEqual, want.Message)
+			}
+		})
+
+		Convey("Then a literal contextual entry beats a lossy generic one", func() {
+			So(match("publish data where wh_id = 'WMD1' | { list orders }"), ShouldEqual, "publish literal contextual")
+			So(match("publish data where wh_id = 'wmd1' | { list orders }"), ShouldEqual, "publish generic")
+		})
+
+		Convey("Then a longer literal prefix beats a shorter lossy one", func() {
+			So(match("list parts where descr = 'Xylophone'"), ShouldEqual, "prefix literal long")
+			So(match("list parts where descr = 'xylophone'"), ShouldEqual, "prefix short")
+		})
+	})
+}
+
+// benchmarkEntries returns n entries split between exact, publish_data and
+// prefix matches, the shape of a recorded fixture set.
+func benchmarkEntries(n int) []Entry {
+	entries := make([]Entry, 0, n)
+	for i := 0; len(entries) < n; i++ {
+		var e Entry
+		switch i % 3 {
+		case 0:
+			e = newEntry(MatchTypeExact, Response{StatusCode: StatusOK})
+			e.Query = normalizeQuery(fmt.Sprintf("list inventory where lodnum = 'LOD%06d'", i))
+		case 1:
+			e = newEntry(MatchTypePublishData, Response{StatusCode: StatusOK})
+			e.Inner = normalizeQuery(fmt.Sprintf("get order %d", i))
+			e.Context = map[string]string{"wh_id": "wmd1"}
+		case 2:
+			e = newEntry(MatchTypePrefix, Response{StatusCode: StatusOK})
+			e.Prefix = normalizeQuery(fmt.Sprintf("list locations where stoloc = 'LOC%06d'", i))
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// benchmarkQueries returns queries hitting the last entry of each match
+// type among n benchmark entries, and one matching nothing.
+func benchmarkQueries(n int) map[string]string {
+	last := func(mod int) int {
+		i := n - 1
+		for i%3 != mod {
+			i--
+		}
+		return max(i, mod)
+	}
+	return map[string]string{
+		"exact":        fmt.Sprintf("list inventory where lodnum = 'LOD%06d'", last(0)),
+		"publish_data": fmt.Sprintf("publish data where wh_id = 'WMD1' | { get order %d }", last(1)),
+		"prefix":       fmt.Sprintf("list locations where stoloc = 'LOC%06d' and wh_id = 'WMD1'", last(2)),
+		"miss":         "list nothing where x = 1",
+	}
+}
+
+func BenchmarkResponseLookup_GetResponse(b *testing.B) {
+	for _, n := range []int{10, 1000, 100000} {
+		lookup, err := NewResponseLookup(NewInMemoryResponseLoader(WithEntries(benchmarkEntries(n))))
+		if err != nil {
+			b.Fatal(err)
+		}
+		lookup.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+		for _, kind := range []string{"exact", "publish_data", "prefix", "miss"} {
+			q := normalizeLiteralQuery(benchmarkQueries(n)[kind])
+			b.Run(fmt.Sprintf("entries=%d/%s", n, kind), func(b *testing.B) {
+				for b.Loop() {
+					lookup.GetResponse(q)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkMatchQuery measures the linear scan the index replaces.
+func BenchmarkMatchQuery(b *testing.B) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, n := range []int{10, 1000, 100000} {
+		entries := benchmarkEntries(n)
+		for _, kind := range []string{"exact", "publish_data", "prefix", "miss"} {
+			q := normalizeLiteralQuery(benchmarkQueries(n)[kind])
+			b.Run(fmt.Sprintf("entries=%d/%s", n, kind), func(b *testing.B) {
+				for b.Loop() {
+					matchQuery(q, entries, logger)
+				}
+			})
+		}
+	}
+}
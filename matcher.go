@@ -1,58 +1,255 @@
 package mocka
 
 import (
+	"cmp"
+	"context"
 	"fmt"
 	"log/slog"
+	"maps"
+	"slices"
 	"strings"
 )
 
-// matchQuery implements the three-step matching hierarchy defined in
-// docs/architecture.md. It is the single entry point for query resolution.
+// matchQuery implements the matching hierarchy defined in
+// docs/architecture.md by scanning entries. It is the reference definition
+// of query resolution; ResponseLookup answers the same question from a
+// matchIndex, which must agree with it.
 //
 // Order:
 //  1. Exact match
 //  2. Publish-data contextual match (with context, then without)
-//  3. Prefix match
-//  4. No match → StatusCommandNotFound
+//  3. SQL match
+//  4. Groovy match
+//  5. Prefix match (longest prefix wins)
+//  6. No match → StatusCommandNotFound
+//
+// Entries with a higher Priority are tried first, through all five steps,
+// before entries with a lower one.
 func matchQuery(query string, entries []Entry, logger *slog.Logger) Response {
 	logger.Debug("matching query", "query", query)
+	if r, ok := findMatch(query, entries, logger); ok {
+		return r
+	}
+	return noMatch(query, logger)
+}
+
+// noMatch is step 6 of the matching hierarchy: the 501 response for query.
+// The message always shows the lossy form, even for a literal query.
+func noMatch(query string, logger *slog.Logger) Response {
+	if logger.Enabled(context.Background(), slog.LevelDebug) {
+		attrs := []any{"query", query}
+		if script, ok := parseGroovyQuery(query, NormalizationLossy); ok {
+			// Log the fingerprint so a groovy entry can be written for it.
+			attrs = append(attrs, "groovy_fingerprint", script.fingerprint)
+		}
+		logger.Debug("no match", attrs...)
+	}
+	return Response{
+		StatusCode: StatusCommandNotFound,
+		Message:    fmt.Sprintf("Command (%s) not found", normalizeQuery(query)),
+	}
+}
+
+// findMatch runs steps 1–5 of the matching hierarchy and reports whether any
+// entry matched. Entries are tried in tiers of equal Priority, highest first;
+// within a tier the hierarchy and then load order decide.
+func findMatch(query string, entries []Entry, logger *slog.Logger) (Response, bool) {
+	forms := newQueryForms(query)
+	if !slices.IsSortedFunc(entries, comparePriority) {
+		entries = sortByPriority(entries)
+	}
+	for len(entries) > 0 {
+		n := 1
+		for n < len(entries) && entries[n].Priority == entries[0].Priority {
+			n++
+		}
+		if r, ok := matchTier(forms, entries[:n], logger); ok {
+			return r, true
+		}
+		entries = entries[n:]
+	}
+	return Response{}, false
+}
+
+// matchTier runs steps 1–5 of the matching hierarchy over entries of equal
+// priority.
+func matchTier(forms *queryForms, entries []Entry, logger *slog.Logger) (Response, bool) {
 
 	// 1. Exact match
 	for _, e := range entries {
-		if e.MatchType == MatchTypeExact && e.Query == query {
-			logger.Debug("exact match", "query", query)
-			return e.response()
+		if e.MatchType == MatchTypeExact && e.Query == forms.query(e.Normalization) && forms.argsMatch(e) {
+			logger.Debug("exact match", "query", e.Query, "priority", e.Priority)
+			return e.response(), true
 		}
 	}
 
 	// 2. Publish-data contextual match
-	if pd, ok := parsePublishData(query); ok {
-		// Contextual match (entry has context that matches)
-		if r, ok := findPublishData(pd, entries, true); ok {
-			logger.Debug("publish_data contextual match", "inner", pd.inner)
-			return r
+	// Contextual match (entry has context that matches)
+	if r, ok := findPublishData(forms, entries, true); ok {
+		logger.Debug("publish_data contextual match", "inner", r.inner, "priority", entries[0].Priority)
+		return r.response, true
+	}
+	// Generic fallback (entry has no context)
+	if r, ok := findPublishData(forms, entries, false); ok {
+		logger.Debug("publish_data generic match", "inner", r.inner, "priority", entries[0].Priority)
+		return r.response, true
+	}
+
+	// 3. SQL match
+	for _, e := range entries {
+		if e.MatchType != MatchTypeSQL {
+			continue
 		}
-		// Generic fallback (entry has no context)
-		if r, ok := findPublishData(pd, entries, false); ok {
-			logger.Debug("publish_data generic match", "inner", pd.inner)
-			return r
+		if stmt, ok := forms.sqlStatement(e.Normalization); ok && sqlMatches(e, stmt) && forms.argsMatch(e) {
+			logger.Debug("sql match", "entry", e.describe(), "priority", e.Priority)
+			return e.response(), true
 		}
 	}
 
-	// 3. Prefix match
+	// 4. Groovy match
 	for _, e := range entries {
-		if e.MatchType == MatchTypePrefix && strings.HasPrefix(query, e.Prefix) {
-			logger.Debug("prefix match", "prefix", e.Prefix)
-			return e.response()
+		if e.MatchType != MatchTypeGroovy {
+			continue
+		}
+		if script, ok := forms.groovyScript(e.Normalization); ok && groovyMatches(e, script) && forms.argsMatch(e) {
+			logger.Debug("groovy match", "entry", e.describe(), "priority", e.Priority)
+			return e.response(), true
 		}
 	}
 
-	// 4. No match
-	logger.Debug("no match", "query", query)
-	return Response{
-		StatusCode: StatusCommandNotFound,
-		Message:    fmt.Sprintf("Command (%s) not found", query),
+	// 5. Prefix match (longest prefix wins)
+	best := -1
+	for i, e := range entries {
+		if e.MatchType == MatchTypePrefix && (best < 0 || len(e.Prefix) > len(entries[best].Prefix)) &&
+			strings.HasPrefix(forms.query(e.Normalization), e.Prefix) && forms.argsMatch(e) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		e := entries[best]
+		logger.Debug("prefix match", "prefix", e.Prefix, "priority", e.Priority)
+		return e.response(), true
+	}
+	return Response{}, false
+}
+
+// comparePriority orders entries by descending Priority.
+func comparePriority(a, b Entry) int {
+	return cmp.Compare(b.Priority, a.Priority)
+}
+
+// sortByPriority returns entries stably sorted by descending Priority, so
+// entries of equal priority keep their load order.
+func sortByPriority(entries []Entry) []Entry {
+	sorted := slices.Clone(entries)
+	slices.SortStableFunc(sorted, comparePriority)
+	return sorted
+}
+
+// queryForms holds an incoming query in both normalization modes, so each
+// entry is compared with the form its patterns were normalized in. The
+// publish data and SQL parses of each form are computed on first use.
+type queryForms struct {
+	raw            string
+	literal, lossy string
+	parsed         map[NormalizationMode]*publishDataResult
+	statements     map[NormalizationMode]*sqlResult
+	scripts        map[NormalizationMode]*groovyResult
+	args           map[NormalizationMode]map[string]string
+}
+
+type publishDataResult struct {
+	pd publishDataParsed
+	ok bool
+}
+
+type sqlResult struct {
+	stmt sqlStatement
+	ok   bool
+}
+
+type groovyResult struct {
+	script groovyScript
+	ok     bool
+}
+
+// newQueryForms derives both forms from query, which may be raw or
+// normalized in either mode. The raw query is kept for Groovy parsing.
+func newQueryForms(query string) *queryForms {
+	return &queryForms{raw: query, literal: normalizeLiteralQuery(query), lossy: normalizeQuery(query)}
+}
+
+func (f *queryForms) query(mode NormalizationMode) string {
+	if mode == NormalizationLiteral {
+		return f.literal
+	}
+	return f.lossy
+}
+
+func (f *queryForms) publishData(mode NormalizationMode) (publishDataParsed, bool) {
+	if mode != NormalizationLiteral || f.literal == f.lossy {
+		mode = NormalizationLossy
+	}
+	if r, ok := f.parsed[mode]; ok {
+		return r.pd, r.ok
+	}
+	pd, ok := parsePublishData(f.query(mode), mode)
+	if f.parsed == nil {
+		f.parsed = make(map[NormalizationMode]*publishDataResult, 2)
+	}
+	f.parsed[mode] = &publishDataResult{pd, ok}
+	return pd, ok
+}
+
+func (f *queryForms) sqlStatement(mode NormalizationMode) (sqlStatement, bool) {
+	if mode != NormalizationLiteral || f.literal == f.lossy {
+		mode = NormalizationLossy
+	}
+	if r, ok := f.statements[mode]; ok {
+		return r.stmt, r.ok
+	}
+	stmt, ok := parseSQLQuery(f.query(mode))
+	if f.statements == nil {
+		f.statements = make(map[NormalizationMode]*sqlResult, 2)
+	}
+	f.statements[mode] = &sqlResult{stmt, ok}
+	return stmt, ok
+}
+
+func (f *queryForms) groovyScript(mode NormalizationMode) (groovyScript, bool) {
+	if mode != NormalizationLiteral || f.literal == f.lossy {
+		mode = NormalizationLossy
+	}
+	if r, ok := f.scripts[mode]; ok {
+		return r.script, r.ok
+	}
+	script, ok := parseGroovyQuery(f.raw, mode)
+	if f.scripts == nil {
+		f.scripts = make(map[NormalizationMode]*groovyResult, 2)
 	}
+	f.scripts[mode] = &groovyResult{script, ok}
+	return script, ok
+}
+
+// argsMatch reports whether the arguments of the query form for e's
+// normalization satisfy e's arg predicates.
+func (f *queryForms) argsMatch(e Entry) bool {
+	if len(e.Args) == 0 {
+		return true
+	}
+	mode := e.Normalization
+	if mode != NormalizationLiteral || f.literal == f.lossy {
+		mode = NormalizationLossy
+	}
+	args, ok := f.args[mode]
+	if !ok {
+		args = queryArgs(f.query(mode), mode)
+		if f.args == nil {
+			f.args = make(map[NormalizationMode]map[string]string, 2)
+		}
+		f.args[mode] = args
+	}
+	return argsMatch(e, args)
 }
 
 // publishDataParsed holds the components extracted from a
@@ -62,57 +259,101 @@ type publishDataParsed struct {
 	context map[string]string
 }
 
-// parsePublishData parses a publish-data contextual query. Returns (parsed,
-// true) if the query matches the pattern, (zero, false) otherwise.
-func parsePublishData(query string) (publishDataParsed, bool) {
-	const pfx = "publish data where "
-	if !strings.HasPrefix(query, pfx) {
+// parsePublishData parses a publish-data contextual query, which may nest
+// any number of publish data layers, normalizing the inner query and context
+// values according to mode. Returns (parsed, true) if the query matches the
+// pattern, (zero, false) otherwise.
+func parsePublishData(query string, mode NormalizationMode) (publishDataParsed, bool) {
+	node, err := parseQuery(query)
+	if err != nil {
 		return publishDataParsed{}, false
 	}
-	pipeIdx := strings.Index(query, "| {")
-	if pipeIdx < 0 {
+	ctx, inner, ok := flattenPublishData(node)
+	if !ok || len(ctx) == 0 {
 		return publishDataParsed{}, false
 	}
-	whereClause := strings.TrimSpace(query[len(pfx):pipeIdx])
-	innerPart := strings.TrimSpace(query[pipeIdx+3:])
-	if !strings.HasSuffix(innerPart, "}") {
-		return publishDataParsed{}, false
-	}
-	inner := normalizeQuery(strings.TrimSuffix(innerPart, "}"))
+	return publishDataParsed{inner: normalizeQueryMode(inner.span().source(query), mode), context: normalizeContext(ctx, mode)}, true
+}
 
-	ctx := make(map[string]string)
-	for _, cond := range strings.Split(whereClause, " and ") {
-		parts := strings.SplitN(cond, " = ", 2)
-		if len(parts) == 2 {
-			key := strings.TrimSpace(parts[0])
-			val := strings.ToLower(strings.Trim(strings.TrimSpace(parts[1]), `'"`))
-			ctx[key] = val
+// flattenPublishData unwraps the publish data layers around node, such as
+//
+//	publish data where a = 1 | publish data where b = 2 | { publish data where c = 3 | { inner } }
+//
+// It returns the combined context of every layer, with inner layers winning
+// on conflicting keys, and the innermost command (or remaining pipeline).
+// ok is false if node does not start with a publish data stage.
+func flattenPublishData(node queryNode) (ctx map[string]string, inner queryNode, ok bool) {
+	ctx = make(map[string]string)
+	for {
+		switch n := node.(type) {
+		case *blockNode:
+			node = n.body
+			continue
+		case *pipelineNode:
+			i := 0
+			for ; i < len(n.stages)-1; i++ {
+				publish, isPublish := n.stages[i].(*commandNode)
+				if !isPublish || publish.verb != "publish data" {
+					break
+				}
+				maps.Copy(ctx, publish.equalArgs())
+			}
+			if i > 0 {
+				ok = true
+				if rest := n.stages[i:]; len(rest) == 1 {
+					node = rest[0]
+				} else {
+					node = &pipelineNode{nodeSpan{rest[0].span().pos, n.end}, rest}
+				}
+				continue
+			}
 		}
+		return ctx, node, ok
 	}
-	return publishDataParsed{inner: inner, context: ctx}, true
 }
 
-// findPublishData searches entries for a publish_data match.
+// publishDataMatch is a publish_data entry matched by findPublishData.
+type publishDataMatch struct {
+	response Response
+	inner    string
+}
+
+// findPublishData searches entries for a publish_data match, comparing each
+// entry with the publish data parse of the query form for its normalization.
 // When withContext is true it only considers entries that have context and
-// whose context key/value pairs all appear in pd.context (order-insensitive).
-// When withContext is false it only considers entries without context.
-func findPublishData(pd publishDataParsed, entries []Entry, withContext bool) (Response, bool) {
+// whose context key/value pairs all appear in the parsed context
+// (order-insensitive). When withContext is false it only considers entries
+// without context.
+func findPublishData(forms *queryForms, entries []Entry, withContext bool) (publishDataMatch, bool) {
 	for _, e := range entries {
 		if e.MatchType != MatchTypePublishData {
 			continue
 		}
-		if e.Inner != pd.inner {
+		pd, ok := forms.publishData(e.Normalization)
+		if !ok || e.Inner != pd.inner || !forms.argsMatch(e) {
 			continue
 		}
 		hasCtx := len(e.Context) > 0
 		if withContext && hasCtx && contextMatches(e.Context, pd.context) {
-			return e.response(), true
+			return publishDataMatch{e.response(), e.Inner}, true
 		}
 		if !withContext && !hasCtx {
-			return e.response(), true
+			return publishDataMatch{e.response(), e.Inner}, true
 		}
 	}
-	return Response{}, false
+	return publishDataMatch{}, false
+}
+
+// normalizeContext normalizes publish data context values for comparison:
+// lowercased in NormalizationLossy, as written in NormalizationLiteral. It
+// returns ctx, modified in place.
+func normalizeContext(ctx map[string]string, mode NormalizationMode) map[string]string {
+	if mode != NormalizationLiteral {
+		for k, v := range ctx {
+			ctx[k] = strings.ToLower(v)
+		}
+	}
+	return ctx
 }
 
 // contextMatches returns true if every key/value pair in registered is present
@@ -263,5 +263,142 @@ func TestMatchQuery_MatchPriority(t *testing.T) {
 			So(r.StatusCode, ShouldEqual, StatusOK)
 			So(r.ResultSet, ShouldEqual, "<thing/>")
 		})
+
+		Convey("A higher entry priority beats the hierarchy", func() {
+			entries := []Entry{
+				{MatchType: MatchTypePublishData, Inner: normalizeQuery("list orders"), StatusCode: StatusOK, Message: "generic"},
+				{MatchType: MatchTypePrefix, Prefix: normalizeQuery("publish data where ordtyp = 'R'"), StatusCode: StatusOK, Message: "returns", Priority: 10},
+			}
+			So(matchQuery(normalizeQuery("publish data where ordtyp = 'R' | { list orders }"), entries, logger).Message, ShouldEqual, "returns")
+			So(matchQuery(normalizeQuery("publish data where ordtyp = 'S' | { list orders }"), entries, logger).Message, ShouldEqual, "generic")
+		})
+
+		Convey("A negative priority makes a last-resort fallback", func() {
+			entries := []Entry{
+				{MatchType: MatchTypeExact, Query: normalizeQuery("list orders"), StatusCode: StatusOK, Message: "fallback", Priority: -1},
+				{MatchType: MatchTypePrefix, Prefix: normalizeQuery("list"), StatusCode: StatusOK, Message: "prefix"},
+			}
+			So(matchQuery(normalizeQuery("list orders"), entries, logger).Message, ShouldEqual, "prefix")
+			So(matchQuery(normalizeQuery("list orders"), entries[:1], logger).Message, ShouldEqual, "fallback")
+		})
+
+		Convey("Equal priorities fall back to the hierarchy, then prefix length", func() {
+			entries := []Entry{
+				{MatchType: MatchTypePrefix, Prefix: normalizeQuery("list"), StatusCode: StatusOK, Message: "first prefix", Priority: 5},
+				{MatchType: MatchTypePrefix, Prefix: normalizeQuery("list orders"), StatusCode: StatusOK, Message: "second prefix", Priority: 5},
+				{MatchType: MatchTypeExact, Query: normalizeQuery("list orders"), StatusCode: StatusOK, Message: "exact", Priority: 5},
+			}
+			So(matchQuery(normalizeQuery("list orders"), entries, logger).Message, ShouldEqual, "exact")
+			So(matchQuery(normalizeQuery("list orders where x = 1"), entries, logger).Message, ShouldEqual, "second prefix")
+			So(matchQuery(normalizeQuery("list areas"), entries, logger).Message, ShouldEqual, "first prefix")
+		})
+	})
+}
+
+func TestMatchQuery_NestedPublishData(t *testing.T) {
+
+	logger := slog.Default()
+
+	Convey("nested publish-data matching", t, func() {
+
+		entries := []Entry{
+			{
+				MatchType:  MatchTypePublishData,
+				Inner:      normalizeQuery("do thing"),
+				Context:    map[string]string{"a": "1", "b": "2"},
+				StatusCode: StatusOK,
+				ResultSet:  "<both/>",
+			},
+			{
+				MatchType:  MatchTypePublishData,
+				Inner:      normalizeQuery("do thing"),
+				Context:    map[string]string{"a": "3"},
+				StatusCode: StatusOK,
+				ResultSet:  "<inner-a/>",
+			},
+		}
+
+		Convey("Given publish data layers piped into each other", func() {
+			q := normalizeQuery("publish data where a = 1 | publish data where b = 2 | { do thing }")
+			r := matchQuery(q, entries, logger)
+			Convey("Then their contexts are combined", func() {
+				So(r.ResultSet, ShouldEqual, "<both/>")
+			})
+		})
+
+		Convey("Given publish data layers nested in blocks", func() {
+			q := normalizeQuery("publish data where a = 1 | { publish data where b = 2 | { publish data where c = 9 | { do thing } } }")
+			r := matchQuery(q, entries, logger)
+			Convey("Then the innermost command is matched with the combined context", func() {
+				So(r.ResultSet, ShouldEqual, "<both/>")
+			})
+		})
+
+		Convey("Given the same key published at two levels", func() {
+			q := normalizeQuery("publish data where a = 1 and b = 2 | { publish data where a = 3 | { do thing } }")
+			r := matchQuery(q, entries, logger)
+			Convey("Then the inner value wins", func() {
+				So(r.ResultSet, ShouldEqual, "<inner-a/>")
+			})
+		})
+
+		Convey("Given an innermost pipeline", func() {
+			pd, ok := parsePublishData(normalizeQuery("publish data where a = 1 | publish data where b = 2 | list things | { do thing }"), NormalizationLossy)
+			Convey("Then the remaining stages form the inner command", func() {
+				So(ok, ShouldBeTrue)
+				So(pd.inner, ShouldEqual, "list things | { do thing }")
+				So(pd.context, ShouldResemble, map[string]string{"a": "1", "b": "2"})
+			})
+		})
+	})
+}
+
+func TestMatchQuery_LiteralNormalization(t *testing.T) {
+
+	logger := slog.Default()
+
+	Convey("literal-preserving normalization", t, func() {
+
+		entries := []Entry{
+			{
+				MatchType:     MatchTypeExact,
+				Query:         normalizeLiteralQuery("list items where descr = 'A=B'"),
+				Normalization: NormalizationLiteral,
+				ResultSet:     "<upper/>",
+			},
+			{
+				MatchType:     MatchTypePublishData,
+				Inner:         normalizeLiteralQuery("list items"),
+				Context:       map[string]string{"descr": "Mixed"},
+				Normalization: NormalizationLiteral,
+				ResultSet:     "<context/>",
+			},
+			{
+				MatchType: MatchTypeExact,
+				Query:     normalizeQuery("list items where descr = 'a = b'"),
+				ResultSet: "<lossy/>",
+			},
+		}
+
+		Convey("Given a query whose literal matches a literal entry", func() {
+			r := matchQuery(normalizeLiteralQuery("LIST ITEMS WHERE descr='A=B'"), entries, logger)
+			Convey("Then the literal entry matches", func() {
+				So(r.ResultSet, ShouldEqual, "<upper/>")
+			})
+		})
+
+		Convey("Given a query differing only in literal case and spacing", func() {
+			r := matchQuery(normalizeLiteralQuery("list items where descr = 'a = B'"), entries, logger)
+			Convey("Then the literal entry does not match but a lossy entry still does", func() {
+				So(r.ResultSet, ShouldEqual, "<lossy/>")
+			})
+		})
+
+		Convey("Given publish data context values", func() {
+			Convey("Then literal entries compare them case-sensitively", func() {
+				So(matchQuery(normalizeLiteralQuery("publish data where descr = 'Mixed' | { list items }"), entries, logger).ResultSet, ShouldEqual, "<context/>")
+				So(matchQuery(normalizeLiteralQuery("publish data where descr = 'mixed' | { list items }"), entries, logger).StatusCode, ShouldEqual, StatusCommandNotFound)
+			})
+		})
 	})
 }
@@ -0,0 +1,292 @@
+package mocka
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/castingcode/mocaprotocol"
+)
+
+// WithPipelineEvaluation makes the handler evaluate piped and
+// multi-statement queries stage by stage when the query as a whole matches
+// no entry. Each stage is resolved against the ResponseLookup and the
+// results are composed the way MOCA would:
+//
+//   - "a | b" runs b once per row of a, with the row's columns bound as
+//     @variables, and concatenates the rows b returns. Variables no stage
+//     binds are looked up in the request's environment.
+//   - "a ; b" runs both and returns the result of b.
+//   - "publish data where ..." needs no entry; it returns one row holding
+//     its arguments.
+//
+// A stage that returns a non-zero status stops evaluation, and its status
+// and message become the response. A stage whose result set cannot be
+// generated or parsed fails the request with an HTTP 500, as it would
+// outside a pipeline. Null columns are bound as empty values.
+func WithPipelineEvaluation() MocaRequestHandlerOption {
+	return func(h *MocaRequestHandler) {
+		h.pipelines = true
+	}
+}
+
+// pipelineEvaluator evaluates the stages of one parsed query.
+type pipelineEvaluator struct {
+	lookup *ResponseLookup
+	query  string            // normalized query the AST was parsed from
+	env    map[string]string // request environment, beneath stage variables
+	// requireSession is set if any resolved stage requires a session.
+	requireSession bool
+	// err is set by the first stage whose result set cannot be produced;
+	// evaluation stops there.
+	err error
+}
+
+// evaluatePipeline evaluates query stage by stage, with env as the
+// variables no stage binds. It reports false if query is not a pipeline,
+// sequence or block.
+func (h *MocaRequestHandler) evaluatePipeline(query string, env map[string]string) (Response, bool) {
+	node, err := parseQuery(query)
+	if err != nil {
+		return Response{}, false
+	}
+	switch node.(type) {
+	case *pipelineNode, *sequenceNode, *blockNode:
+	default:
+		return Response{}, false
+	}
+	ev := &pipelineEvaluator{lookup: h.lookup, query: query, env: env}
+	response := ev.eval(node, nil)
+	if ev.err != nil {
+		response = failedResponse(ev.err)
+	}
+	response.RequireSession = ev.requireSession
+	return response, true
+}
+
+// eval evaluates node with vars, the variables published by earlier
+// stages, bound as @variables over ev.env. @* expands to vars only. The
+// returned Response always carries parsed results.
+func (ev *pipelineEvaluator) eval(node queryNode, vars map[string]string) Response {
+	switch n := node.(type) {
+	case *blockNode:
+		return ev.eval(n.body, vars)
+	case *sequenceNode:
+		var last Response
+		for _, stmt := range n.stmts {
+			last = ev.eval(stmt, vars)
+			if last.StatusCode != StatusOK || ev.err != nil {
+				return last
+			}
+		}
+		return last
+	case *pipelineNode:
+		return ev.pipe(n.stages, vars)
+	case *commandNode:
+		if n.verb == "publish data" {
+			return publishDataResponse(n, ev.bound(vars), vars)
+		}
+		return ev.resolve(n.render(ev.bound(vars), vars))
+	case *sqlNode:
+		return ev.resolve("[" + bindSQLVariables(n.text, ev.bound(vars)) + "]")
+	}
+	return ev.resolve(node.span().source(ev.query))
+}
+
+// bound returns the variables visible to a stage: vars over ev.env.
+func (ev *pipelineEvaluator) bound(vars map[string]string) map[string]string {
+	if len(ev.env) == 0 {
+		return vars
+	}
+	all := maps.Clone(ev.env)
+	maps.Copy(all, vars)
+	return all
+}
+
+// pipe runs stages[0] and pipes each of its rows into the remaining stages.
+func (ev *pipelineEvaluator) pipe(stages []queryNode, vars map[string]string) Response {
+	left := ev.eval(stages[0], vars)
+	if left.StatusCode != StatusOK || ev.err != nil || len(stages) == 1 {
+		return left
+	}
+	results, _ := left.results()
+	if results == nil || len(results.Data.Rows) == 0 {
+		return left
+	}
+	var combined *mocaprotocol.MocaResults
+	last := left
+	for _, row := range results.Data.Rows {
+		rowVars := maps.Clone(vars)
+		if rowVars == nil {
+			rowVars = make(map[string]string)
+		}
+		for i, col := range results.Metadata.Columns {
+			if i < len(row.Fields) {
+				// A null field has an empty Value, which MOCA binds too.
+				rowVars[strings.ToLower(col.Name)] = row.Fields[i].Value
+			}
+		}
+		last = ev.pipe(stages[1:], rowVars)
+		if last.StatusCode != StatusOK || ev.err != nil {
+			return last
+		}
+		rowResults, _ := last.results()
+		switch {
+		case rowResults == nil:
+		case combined == nil:
+			combined = &mocaprotocol.MocaResults{Metadata: rowResults.Metadata}
+			combined.Data.Rows = slices.Clone(rowResults.Data.Rows)
+		default:
+			combined.Data.Rows = append(combined.Data.Rows, rowResults.Data.Rows...)
+		}
+	}
+	return parsedResponse(StatusOK, last.Message, combined)
+}
+
+// resolve looks up a single stage.
+func (ev *pipelineEvaluator) resolve(stage string) Response {
+	response := ev.lookup.GetResponse(normalizeLiteralQuery(stage))
+	if response.RequireSession {
+		ev.requireSession = true
+	}
+	results, err := response.results()
+	if err != nil {
+		if ev.err == nil {
+			ev.err = fmt.Errorf("stage %s: %w", stage, err)
+		}
+		return failedResponse(ev.err)
+	}
+	return parsedResponse(response.StatusCode, response.Message, results)
+}
+
+// failedResponse returns a Response whose result set fails with err, so
+// that writeResponse answers with an HTTP 500.
+func failedResponse(err error) Response {
+	parsed := &parsedResultSet{err: err}
+	parsed.once.Do(func() {})
+	return Response{StatusCode: StatusOK, parsed: parsed}
+}
+
+// parsedResponse returns a Response whose result set is already parsed.
+func parsedResponse(status int, message string, results *mocaprotocol.MocaResults) Response {
+	parsed := &parsedResultSet{results: results}
+	parsed.once.Do(func() {})
+	return Response{StatusCode: status, Message: message, parsed: parsed}
+}
+
+// publishDataResponse returns the single row published by a
+// "publish data" command, binding @variables as bindArgs does.
+func publishDataResponse(cmd *commandNode, vars, star map[string]string) Response {
+	results := &mocaprotocol.MocaResults{}
+	row := mocaprotocol.Row{}
+	for _, arg := range cmd.bindArgs(vars, star) {
+		if arg.op != "=" || arg.value.kind == valueVariable {
+			continue
+		}
+		results.Metadata.Columns = append(results.Metadata.Columns,
+			mocaprotocol.Column{Name: arg.name, Type: mocaprotocol.MocaString, Nullable: "true", Length: "0"})
+		row.Fields = append(row.Fields, mocaprotocol.Field{Value: arg.value.text})
+	}
+	results.Data.Rows = []mocaprotocol.Row{row}
+	return parsedResponse(StatusOK, "", results)
+}
+
+// bindArgs returns cmd's arguments with bound @variables replaced by their
+// values from vars. @* expands to every variable in star not already named.
+func (cmd *commandNode) bindArgs(vars, star map[string]string) []argNode {
+	var args []argNode
+	named := make(map[string]bool)
+	for _, a := range cmd.args {
+		named[a.name] = true
+	}
+	for _, a := range cmd.args {
+		if a.value.kind != valueVariable {
+			args = append(args, a)
+			continue
+		}
+		name := strings.TrimLeft(a.value.text, "+-%")
+		if name == "*" {
+			for _, k := range slices.Sorted(maps.Keys(star)) {
+				if !named[k] {
+					args = append(args, argNode{name: k, op: "=", value: valueNode{valueString, star[k]}})
+				}
+			}
+			continue
+		}
+		if v, ok := vars[name]; ok {
+			if a.name == "" {
+				a.name = name // @+name binds name = @name
+			}
+			a.value = valueNode{valueString, v}
+		}
+		args = append(args, a)
+	}
+	return args
+}
+
+// render returns cmd as local syntax with bound @variables substituted, as
+// by bindArgs.
+func (cmd *commandNode) render(vars, star map[string]string) string {
+	args := cmd.bindArgs(vars, star)
+	if len(args) == 0 {
+		return cmd.verb
+	}
+	parts := make([]string, len(args))
+	for i, a := range args {
+		parts[i] = a.render()
+	}
+	return cmd.verb + " where " + strings.Join(parts, " and ")
+}
+
+func (a argNode) render() string {
+	if a.op == "is null" || a.op == "is not null" {
+		return a.name + " " + a.op
+	}
+	var value string
+	switch a.value.kind {
+	case valueString:
+		value = quoteString(a.value.text)
+	case valueVariable:
+		value = "@" + a.value.text
+	default:
+		value = a.value.text
+	}
+	if a.name == "" {
+		return value
+	}
+	return a.name + " " + a.op + " " + value
+}
+
+// quoteString quotes s as a MOCA string literal.
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// bindSQLVariables replaces bound @variables in embedded SQL with quoted
+// values, leaving unbound ones and anything inside string literals alone.
+func bindSQLVariables(sql string, vars map[string]string) string {
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		if c == '\'' {
+			quoted = !quoted
+		}
+		if c != '@' || quoted {
+			b.WriteByte(c)
+			continue
+		}
+		j := i + 1
+		for j < len(sql) && (sql[j] == '_' || 'a' <= sql[j] && sql[j] <= 'z' || '0' <= sql[j] && sql[j] <= '9') {
+			j++
+		}
+		if v, ok := vars[sql[i+1:j]]; ok && j > i+1 {
+			b.WriteString(quoteString(v))
+			i = j - 1
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
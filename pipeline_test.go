@@ -0,0 +1,146 @@
+package mocka
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/castingcode/mocaprotocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func pipelineMux(t *testing.T, opts ...MocaRequestHandlerOption) *http.ServeMux {
+	t.Helper()
+	resultSet := func(rs *ResultSetBuilder) Response {
+		rb, err := NewResponse(StatusOK).WithResultSetBuilder(rs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rb.Build()
+	}
+	lookup, err := NewResponseLookup(NewInMemoryResponseLoader(
+		WithExactMatch("list warehouses", resultSet(
+			NewResultSet().Column("wh_id", TypeString).Row("WH1").Row("WH2"))),
+		WithExactMatch("list locations where wh_id = 'WH1'", resultSet(
+			NewResultSet().Column("stoloc", TypeString).Row("A-01").Row("A-02"))),
+		WithExactMatch("list locations where wh_id = 'WH2'", resultSet(
+			NewResultSet().Column("stoloc", TypeString).Row("B-01"))),
+		WithExactMatch("list inventory where wh_id = 'MHE'", resultSet(
+			NewResultSet().Column("lodnum", TypeString).Row("L001"))),
+		WithExactMatch("list carriers", resultSet(
+			NewResultSet().Column("carcod", TypeString).Row("UPS"))),
+		WithExactMatch("fail here", NewResponse(StatusCommandNotFound).WithMessage("failed on purpose").Build()),
+		WithExactMatch("break here", Response{
+			StatusCode: StatusOK,
+			lazy:       &lazyResultSet{generate: func() (string, error) { return "", errors.New("boom") }},
+		}),
+		WithExactMatch("list locations where wh_id = 'WH1' and usr_id = 'BOB'", resultSet(
+			NewResultSet().Column("stoloc", TypeString).Row("BOB-01"))),
+		WithExactMatch("list locations where wh_id = 'WH2' and usr_id = 'BOB'", resultSet(
+			NewResultSet().Column("stoloc", TypeString).Row("BOB-02"))),
+		WithExactMatch("list unassigned", resultSet(
+			NewResultSet().Column("wh_id", TypeString).Row(nil))),
+		WithExactMatch("list locations where wh_id = ''", resultSet(
+			NewResultSet().Column("stoloc", TypeString).Row("NONE"))),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	RegisterRoutes(mux, NewMocaRequestHandler(lookup, append([]MocaRequestHandlerOption{WithSessionMode(SessionModeDisabled)}, opts...)...))
+	return mux
+}
+
+func pipelineValues(results mocaprotocol.MocaResults) []string {
+	var values []string
+	for _, row := range results.Data.Rows {
+		values = append(values, row.Fields[0].Value)
+	}
+	return values
+}
+
+func TestHandleMocaRequest_PipelineEvaluation(t *testing.T) {
+
+	Convey("Given a handler with pipeline evaluation enabled", t, func() {
+		mux := pipelineMux(t, WithPipelineEvaluation())
+		send := func(query string) mocaprotocol.MocaResponse {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, buildRequest(t, query))
+			return decodeBody(t, w)
+		}
+
+		Convey("a pipe runs the right side once per row and concatenates the results", func() {
+			response := send("list warehouses | list locations where wh_id = @wh_id")
+			So(response.Status, ShouldEqual, StatusOK)
+			So(pipelineValues(response.MocaResults), ShouldResemble, []string{"A-01", "A-02", "B-01"})
+		})
+
+		Convey("publish data binds its arguments for the next stage", func() {
+			response := send("publish data where wh_id = 'MHE' | list inventory where wh_id = @wh_id")
+			So(response.Status, ShouldEqual, StatusOK)
+			So(pipelineValues(response.MocaResults), ShouldResemble, []string{"L001"})
+		})
+
+		Convey("@+name binds the argument by name", func() {
+			response := send("publish data where wh_id = 'MHE' | list inventory where @+wh_id")
+			So(pipelineValues(response.MocaResults), ShouldResemble, []string{"L001"})
+		})
+
+		Convey("a sequence returns the last statement's result", func() {
+			response := send("list warehouses; list carriers")
+			So(response.Status, ShouldEqual, StatusOK)
+			So(pipelineValues(response.MocaResults), ShouldResemble, []string{"UPS"})
+		})
+
+		Convey("a failing stage stops evaluation with its status and message", func() {
+			response := send("fail here; list carriers")
+			So(response.Status, ShouldEqual, StatusCommandNotFound)
+			So(response.Message, ShouldEqual, "failed on purpose")
+		})
+
+		Convey("an unknown stage fails the whole pipe", func() {
+			response := send("list warehouses | list nothing where wh_id = @wh_id")
+			So(response.Status, ShouldEqual, StatusCommandNotFound)
+			So(response.Message, ShouldContainSubstring, "list nothing where wh_id = 'wh1'")
+		})
+
+		Convey("a stage whose result set cannot be generated fails with HTTP 500", func() {
+			for _, q := range []string{"break here; list carriers", "list warehouses | break here"} {
+				w := httptest.NewRecorder()
+				mux.ServeHTTP(w, buildRequest(t, q))
+				So(w.Code, ShouldEqual, http.StatusInternalServerError)
+			}
+		})
+
+		Convey("variables no stage binds come from the environment", func() {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, buildRequest(t, "list warehouses | list locations where wh_id = @wh_id and usr_id = @usr_id",
+				withEnvironmentVar("USR_ID", "BOB"), withEnvironmentVar("WH_ID", "WH9")))
+			response := decodeBody(t, w)
+			So(response.Status, ShouldEqual, StatusOK)
+			So(pipelineValues(response.MocaResults), ShouldResemble, []string{"BOB-01", "BOB-02"})
+		})
+
+		Convey("a null column is bound as an empty value", func() {
+			response := send("list unassigned | list locations where wh_id = @wh_id")
+			So(response.Status, ShouldEqual, StatusOK)
+			So(pipelineValues(response.MocaResults), ShouldResemble, []string{"NONE"})
+		})
+
+		Convey("an entry for the whole query still wins", func() {
+			response := send("list warehouses")
+			So(pipelineValues(response.MocaResults), ShouldResemble, []string{"WH1", "WH2"})
+		})
+	})
+
+	Convey("Given a handler without pipeline evaluation", t, func() {
+		mux := pipelineMux(t)
+
+		Convey("piped queries are not split into stages", func() {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, buildRequest(t, "list warehouses; list carriers"))
+			So(decodeBody(t, w).Status, ShouldEqual, StatusCommandNotFound)
+		})
+	})
+}
@@ -1,14 +1,50 @@
 package mocka
 
 import (
+	"fmt"
 	"strings"
 	"text/scanner"
+	"unicode"
 )
 
-// normalizeQuery lowercases q, normalizes whitespace inside embedded SQL ([...])
-// and Groovy ([[...]]) blocks, canonicalizes double quotes to single quotes in
-// local syntax, adds spaces around = signs, and collapses all remaining
-// whitespace to single spaces with leading/trailing whitespace trimmed.
+// NormalizationMode selects how an entry's query patterns, and the incoming
+// queries compared against them, are normalized before matching.
+type NormalizationMode string
+
+const (
+	// NormalizationLossy lowercases the whole query, string literals
+	// included, and spaces every = sign. This is the default.
+	NormalizationLossy NormalizationMode = "lossy"
+	// NormalizationLiteral normalizes keywords, quotes and whitespace like
+	// NormalizationLossy but leaves the contents of string literals as
+	// written, so 'A=B' and 'a = b' stay distinct.
+	NormalizationLiteral NormalizationMode = "literal"
+)
+
+// ParseNormalizationMode converts s to a NormalizationMode, returning an
+// error for unrecognized values.
+func ParseNormalizationMode(s string) (NormalizationMode, error) {
+	switch m := NormalizationMode(s); m {
+	case NormalizationLossy, NormalizationLiteral:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown normalization mode %q", s)
+}
+
+// normalizeQueryMode normalizes q according to mode. The zero mode is
+// NormalizationLossy.
+func normalizeQueryMode(q string, mode NormalizationMode) string {
+	if mode == NormalizationLiteral {
+		return normalizeLiteralQuery(q)
+	}
+	return normalizeQuery(q)
+}
+
+// normalizeQuery lowercases q, normalizes whitespace inside embedded SQL
+// ([...]) and Groovy ([[...]]) blocks, canonicalizes
+// double quotes to single quotes in local syntax, adds spaces around = signs,
+// and collapses all remaining whitespace to single spaces with
+// leading/trailing whitespace trimmed.
 // All MOCA query comparisons must go through this function.
 func normalizeQuery(q string) string {
 	q = strings.ToLower(q)
@@ -23,8 +59,9 @@ func normalizeQuery(q string) string {
 //  1. Inside embedded SQL ([...]) and Groovy ([[...]]) blocks: whitespace is
 //     trimmed and collapsed, but quotes are preserved as-is.
 //
-//  2. In local syntax (everywhere else): double quotes are canonicalized to
-//     single quotes so that 'foo' and "foo" compare equal.
+//  2. In local syntax (everywhere else): string literals are copied as-is,
+//     except that double-quoted literals are requoted with single quotes so
+//     that 'foo' and "foo" compare equal.
 func processQuerySegments(q string) string {
 	var s scanner.Scanner
 	s.Init(strings.NewReader(q))
@@ -44,9 +81,8 @@ func processQuerySegments(q string) string {
 			out.WriteString("[")
 			out.WriteString(strings.Join(strings.Fields(inner), " "))
 			out.WriteString("]")
-		case ch == '"':
-			// Canonicalize double quotes to single quotes in local syntax.
-			out.WriteRune('\'')
+		case ch == '\'' || ch == '"':
+			out.WriteString(scanString(&s, ch))
 		default:
 			out.WriteRune(ch)
 		}
@@ -54,6 +90,40 @@ func processQuerySegments(q string) string {
 	return out.String()
 }
 
+// scanString reads the rest of a string literal opened by quote from s and
+// returns it requoted with single quotes. A doubled quote becomes a single
+// one and every apostrophe is written doubled:
+//
+//	"it's"      -> 'it''s'
+//	"say ""x""" -> 'say "x"'
+//	'it''s'     -> 'it''s'
+func scanString(s *scanner.Scanner, quote rune) string {
+	var buf strings.Builder
+	buf.WriteRune('\'')
+	for {
+		ch := s.Next()
+		if ch == scanner.EOF {
+			return buf.String()
+		}
+		if ch == quote {
+			if s.Peek() != quote {
+				break
+			}
+			s.Next() // doubled quote escapes itself
+			if quote == '"' {
+				buf.WriteRune(ch)
+				continue
+			}
+		}
+		if ch == '\'' {
+			buf.WriteRune(ch) // a ' is always written escaped as ''
+		}
+		buf.WriteRune(ch)
+	}
+	buf.WriteRune('\'')
+	return buf.String()
+}
+
 // scanUntil reads from s until delim is found, returning all characters read
 // before the delimiter. The delimiter itself is consumed but not returned.
 // delim must be one or two characters.
@@ -84,3 +154,171 @@ func scanUntil(s *scanner.Scanner, delim string) string {
 	}
 	return buf.String()
 }
+
+// stripGroovyComments removes // and /* */ comments from the Groovy
+// ([[...]]) blocks of q, leaving comment markers inside Groovy strings
+// alone. q must not be normalized yet: line comments end at a newline.
+func stripGroovyComments(q string) string {
+	if !strings.Contains(q, "[[") || !strings.Contains(q, "/") {
+		return q
+	}
+	var out strings.Builder
+	for {
+		open := strings.Index(q, "[[")
+		if open < 0 {
+			break
+		}
+		n := strings.Index(q[open+2:], "]]")
+		if n < 0 {
+			break
+		}
+		end := open + 2 + n
+		out.WriteString(q[:open+2])
+		out.WriteString(stripComments(q[open+2 : end]))
+		out.WriteString("]]")
+		q = q[end+2:]
+	}
+	out.WriteString(q)
+	return out.String()
+}
+
+// stripComments removes // and /* */ comments from Groovy source outside
+// string literals. Line comments keep their newline, block comments become
+// a space.
+func stripComments(src string) string {
+	var out strings.Builder
+	for i := 0; i < len(src); {
+		switch {
+		case strings.HasPrefix(src[i:], "//"):
+			j := strings.IndexByte(src[i:], '\n')
+			if j < 0 {
+				return out.String()
+			}
+			i += j
+		case strings.HasPrefix(src[i:], "/*"):
+			j := strings.Index(src[i+2:], "*/")
+			if j < 0 {
+				return out.String()
+			}
+			out.WriteByte(' ')
+			i += 2 + j + 2
+		case src[i] == '\'' || src[i] == '"':
+			j := groovyStringEnd(src, i)
+			out.WriteString(src[i:j])
+			i = j
+		default:
+			out.WriteByte(src[i])
+			i++
+		}
+	}
+	return out.String()
+}
+
+// groovyStringEnd returns the offset just past the Groovy string literal
+// starting at src[i], which may be single, double or triple quoted and may
+// contain backslash escapes. An unterminated string ends at len(src).
+func groovyStringEnd(src string, i int) int {
+	delim := src[i : i+1]
+	if strings.HasPrefix(src[i:], delim+delim+delim) {
+		delim = src[i : i+3]
+	}
+	for j := i + len(delim); j < len(src); j++ {
+		if src[j] == '\\' {
+			j++
+			continue
+		}
+		if strings.HasPrefix(src[j:], delim) {
+			return j + len(delim)
+		}
+	}
+	return len(src)
+}
+
+// normalizeLiteralQuery normalizes q like normalizeQuery everywhere outside
+// string literals, which are copied verbatim apart from double-quoted local
+// syntax literals being requoted with single quotes (see scanString).
+// Because it only applies a subset of normalizeQuery's transformations,
+// normalizeQuery(normalizeLiteralQuery(q)) == normalizeQuery(q).
+func normalizeLiteralQuery(q string) string {
+	var out strings.Builder
+	var (
+		quote   rune // delimiter of the open string literal, or 0
+		block   int  // 1 inside [...], 2 inside [[...]]
+		pending bool // whitespace seen since the last token
+	)
+	runes := []rune(q)
+	emit := func(s string) {
+		if pending && out.Len() > 0 {
+			out.WriteByte(' ')
+		}
+		pending = false
+		out.WriteString(s)
+	}
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		next := rune(0)
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+		switch {
+		case quote != 0:
+			switch {
+			case r == quote && next == quote:
+				// A doubled quote escapes itself; requoted with single
+				// quotes, "" becomes ".
+				i++
+				if block == 0 && quote == '"' {
+					out.WriteRune('"')
+				} else {
+					out.WriteRune(r)
+					out.WriteRune(r)
+				}
+			case r == quote:
+				quote = 0
+				if block == 0 {
+					r = '\''
+				}
+				out.WriteRune(r)
+			case r == '\'' && block == 0:
+				out.WriteString("''") // inside "...", requoted with single quotes
+			default:
+				out.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			if block == 0 {
+				r = '\''
+			}
+			emit(string(r))
+		case r == '[' && block == 0:
+			if next == '[' {
+				block = 2
+				i++
+				emit("[[")
+			} else {
+				block = 1
+				emit("[")
+			}
+		case r == ']' && (block == 1 || block == 2 && next == ']'):
+			pending = false
+			if block == 2 {
+				i++
+				out.WriteString("]]")
+			} else {
+				out.WriteByte(']')
+			}
+			block = 0
+		case r == '=':
+			pending = true
+			emit("=")
+			pending = true
+		case unicode.IsSpace(r):
+			if !strings.HasSuffix(out.String(), "[") {
+				pending = true
+			}
+		default:
+			emit(string(unicode.ToLower(r)))
+		}
+	}
+	return out.String()
+}
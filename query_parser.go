@@ -0,0 +1,531 @@
+package mocka
+
+import (
+	"fmt"
+	"strings"
+)
+
+// This file implements a tokenizer and recursive-descent parser for MOCA
+// local syntax. parseQuery turns a query into an AST of queryNodes, which
+// the matcher and the handler's built-in command detection work from
+// instead of splitting strings.
+//
+// Grammar, lowest precedence first:
+//
+//	sequence := logical { ";" logical } [ ";" ]
+//	logical  := union { ( "||" | "&&" ) union }
+//	union    := pipeline { "&" pipeline }
+//	pipeline := primary { "|" primary }
+//	primary  := "{" sequence "}" | "[" sql "]" | "[[" groovy "]]" | command
+//	command  := word { word } [ "where" arg { "and" arg } ]
+//	arg      := "@*" | "@+" name | name op value | name [ "not" ] "like" value
+//	          | name "is" [ "not" ] "null"
+//	op       := "=" | "!=" | "<>" | "<" | "<=" | ">" | ">="
+//	value    := string | number | word | "@" name
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokVariable
+	tokOp     // comparison operator
+	tokPipe   // |
+	tokAmp    // &
+	tokOr     // ||
+	tokAnd    // &&
+	tokSemi   // ;
+	tokLBrace // {
+	tokRBrace // }
+	tokLParen // (
+	tokRParen // )
+	tokComma  // ,
+	tokSQL    // [...]
+	tokGroovy // [[...]]
+)
+
+var punctuation = map[byte]tokenKind{
+	';': tokSemi, '{': tokLBrace, '}': tokRBrace, '(': tokLParen, ')': tokRParen, ',': tokComma,
+}
+
+// token is a lexical token. text is its value: the unquoted contents of a
+// string, the name of a variable (without @), the body of a SQL or Groovy
+// block, or the canonical operator. pos and end are byte offsets into the
+// query.
+type token struct {
+	kind     tokenKind
+	text     string
+	pos, end int
+}
+
+// querySyntaxError reports where and why a query failed to parse.
+type querySyntaxError struct {
+	pos int
+	msg string
+}
+
+func (e *querySyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at offset %d: %s", e.pos, e.msg)
+}
+
+// isWordByte reports whether c may appear in an unquoted word.
+func isWordByte(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', '\'', '"', '|', '{', '}', ';', '&', '[', ']', '(', ')', '=', '<', '>', '!', ',', '@':
+		return false
+	}
+	return true
+}
+
+// tokenize splits q into tokens, ending with a tokEOF token.
+func tokenize(q string) ([]token, error) {
+	var tokens []token
+	i := 0
+	emit := func(kind tokenKind, text string, pos int) {
+		tokens = append(tokens, token{kind: kind, text: text, pos: pos, end: i})
+	}
+	skipSpace := func(j int) int {
+		for j < len(q) && strings.IndexByte(" \t\n\r", q[j]) >= 0 {
+			j++
+		}
+		return j
+	}
+	for {
+		i = skipSpace(i)
+		if i >= len(q) {
+			emit(tokEOF, "", i)
+			return tokens, nil
+		}
+		start := i
+		c := q[i]
+		switch {
+		case c == '[' && strings.HasPrefix(q[i:], "[["):
+			n := strings.Index(q[i+2:], "]]")
+			if n < 0 {
+				return nil, &querySyntaxError{start, "unterminated groovy block"}
+			}
+			i += 2 + n + 2
+			emit(tokGroovy, q[start+2:i-2], start)
+		case c == '[':
+			j, quoted := i+1, false
+			for j < len(q) && (quoted || q[j] != ']') {
+				if q[j] == '\'' {
+					quoted = !quoted
+				}
+				j++
+			}
+			if j >= len(q) {
+				return nil, &querySyntaxError{start, "unterminated sql block"}
+			}
+			i = j + 1
+			emit(tokSQL, q[start+1:j], start)
+		case c == '\'' || c == '"':
+			var b strings.Builder
+			j := i + 1
+			for {
+				if j >= len(q) {
+					return nil, &querySyntaxError{start, "unterminated string"}
+				}
+				if q[j] == c {
+					if j+1 < len(q) && q[j+1] == c { // doubled quote escapes itself
+						b.WriteByte(c)
+						j += 2
+						continue
+					}
+					break
+				}
+				b.WriteByte(q[j])
+				j++
+			}
+			i = j + 1
+			emit(tokString, b.String(), start)
+		case c == '@':
+			j := i + 1
+			if j < len(q) && (q[j] == '*' || q[j] == '+' || q[j] == '-' || q[j] == '%') {
+				j++
+			}
+			for j < len(q) && isWordByte(q[j]) {
+				j++
+			}
+			if j == i+1 {
+				return nil, &querySyntaxError{start, "expected variable name after @"}
+			}
+			i = j
+			emit(tokVariable, q[start+1:i], start)
+		case c == '=' || c == '<' || c == '>' || c == '!':
+			// Operators may be split by whitespace after normalizeQuery,
+			// which spaces out every '=': "! =" is "!=".
+			i++
+			op := string(c)
+			if j := skipSpace(i); j < len(q) {
+				switch {
+				case c != '=' && q[j] == '=':
+					op, i = op+"=", j+1
+				case c == '<' && q[j] == '>':
+					op, i = "!=", j+1
+				}
+			}
+			if op == "!" {
+				return nil, &querySyntaxError{start, "unexpected !"}
+			}
+			emit(tokOp, op, start)
+		case c == '|' || c == '&':
+			i++
+			double := i < len(q) && q[i] == c
+			if double {
+				i++
+			}
+			switch {
+			case c == '|' && double:
+				emit(tokOr, "||", start)
+			case c == '|':
+				emit(tokPipe, "|", start)
+			case double:
+				emit(tokAnd, "&&", start)
+			default:
+				emit(tokAmp, "&", start)
+			}
+		case strings.IndexByte(";{}(),", c) >= 0:
+			i++
+			emit(punctuation[c], string(c), start)
+		case c == ']':
+			return nil, &querySyntaxError{start, "unexpected ]"}
+		default:
+			for i < len(q) && isWordByte(q[i]) {
+				i++
+			}
+			emit(tokWord, q[start:i], start)
+		}
+	}
+}
+
+// queryNode is a node of a parsed MOCA query.
+type queryNode interface {
+	span() nodeSpan
+}
+
+// nodeSpan is the byte range a node covers in the parsed query.
+type nodeSpan struct {
+	pos, end int
+}
+
+func (s nodeSpan) span() nodeSpan { return s }
+
+// source returns the text of q covered by s.
+func (s nodeSpan) source(q string) string { return q[s.pos:s.end] }
+
+// commandNode is a local syntax command such as
+// "list warehouses where wh_id = 'MHE'".
+type commandNode struct {
+	nodeSpan
+	verb string // command words joined by single spaces
+	args []argNode
+}
+
+// argNode is one where-clause argument. A bare variable argument such as
+// @* has an empty name.
+type argNode struct {
+	name  string
+	op    string // "=", "!=", "<", "<=", ">", ">=", "like", "not like", "is null", "is not null"
+	value valueNode
+}
+
+type valueKind int
+
+const (
+	valueNone valueKind = iota
+	valueString
+	valueWord // unquoted word or number
+	valueVariable
+)
+
+// valueNode is the right-hand side of an argument. text holds the unquoted
+// string, the word, or the variable name without @.
+type valueNode struct {
+	kind valueKind
+	text string
+}
+
+// blockNode is a braced "{ ... }" block.
+type blockNode struct {
+	nodeSpan
+	body queryNode
+}
+
+// sqlNode is an embedded "[...]" SQL statement.
+type sqlNode struct {
+	nodeSpan
+	text string
+}
+
+// groovyNode is an embedded "[[...]]" Groovy script.
+type groovyNode struct {
+	nodeSpan
+	text string
+}
+
+// pipelineNode is two or more stages joined by "|".
+type pipelineNode struct {
+	nodeSpan
+	stages []queryNode
+}
+
+// sequenceNode is two or more statements separated by ";".
+type sequenceNode struct {
+	nodeSpan
+	stmts []queryNode
+}
+
+// binaryNode joins two nodes with "&", "||" or "&&".
+type binaryNode struct {
+	nodeSpan
+	op          string
+	left, right queryNode
+}
+
+// parseQuery parses q as MOCA local syntax.
+func parseQuery(q string) (queryNode, error) {
+	tokens, err := tokenize(q)
+	if err != nil {
+		return nil, err
+	}
+	p := &queryParser{tokens: tokens}
+	node, err := p.sequence()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, &querySyntaxError{t.pos, fmt.Sprintf("unexpected %q", t.text)}
+	}
+	return node, nil
+}
+
+type queryParser struct {
+	tokens []token
+	i      int
+}
+
+func (p *queryParser) peek() token { return p.tokens[p.i] }
+
+func (p *queryParser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// peekWord reports whether the next token is the keyword word.
+func (p *queryParser) peekWord(word string) bool {
+	t := p.peek()
+	return t.kind == tokWord && strings.EqualFold(t.text, word)
+}
+
+func (p *queryParser) errorf(t token, format string, args ...any) error {
+	return &querySyntaxError{t.pos, fmt.Sprintf(format, args...)}
+}
+
+func (p *queryParser) sequence() (queryNode, error) {
+	first, err := p.logical()
+	if err != nil {
+		return nil, err
+	}
+	stmts := []queryNode{first}
+	for p.peek().kind == tokSemi {
+		p.next()
+		if k := p.peek().kind; k == tokEOF || k == tokRBrace {
+			break // trailing ;
+		}
+		stmt, err := p.logical()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, stmt)
+	}
+	if len(stmts) == 1 {
+		return first, nil
+	}
+	return &sequenceNode{nodeSpan{first.span().pos, stmts[len(stmts)-1].span().end}, stmts}, nil
+}
+
+func (p *queryParser) logical() (queryNode, error) {
+	left, err := p.union()
+	if err != nil {
+		return nil, err
+	}
+	for k := p.peek().kind; k == tokOr || k == tokAnd; k = p.peek().kind {
+		op := p.next().text
+		right, err := p.union()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{nodeSpan{left.span().pos, right.span().end}, op, left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) union() (queryNode, error) {
+	left, err := p.pipeline()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokAmp {
+		p.next()
+		right, err := p.pipeline()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{nodeSpan{left.span().pos, right.span().end}, "&", left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) pipeline() (queryNode, error) {
+	first, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	stages := []queryNode{first}
+	for p.peek().kind == tokPipe {
+		p.next()
+		stage, err := p.primary()
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, stage)
+	}
+	if len(stages) == 1 {
+		return first, nil
+	}
+	return &pipelineNode{nodeSpan{first.span().pos, stages[len(stages)-1].span().end}, stages}, nil
+}
+
+func (p *queryParser) primary() (queryNode, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokLBrace:
+		p.next()
+		body, err := p.sequence()
+		if err != nil {
+			return nil, err
+		}
+		end := p.next()
+		if end.kind != tokRBrace {
+			return nil, p.errorf(end, "expected } to close block at offset %d", t.pos)
+		}
+		return &blockNode{nodeSpan{t.pos, end.end}, body}, nil
+	case tokSQL:
+		p.next()
+		return &sqlNode{nodeSpan{t.pos, t.end}, t.text}, nil
+	case tokGroovy:
+		p.next()
+		return &groovyNode{nodeSpan{t.pos, t.end}, t.text}, nil
+	case tokWord:
+		return p.command()
+	case tokEOF:
+		return nil, p.errorf(t, "unexpected end of query")
+	}
+	return nil, p.errorf(t, "unexpected %q", t.text)
+}
+
+func (p *queryParser) command() (queryNode, error) {
+	first := p.peek()
+	var words []string
+	end := first.end
+	for p.peek().kind == tokWord && !p.peekWord("where") {
+		t := p.next()
+		words = append(words, t.text)
+		end = t.end
+	}
+	if len(words) == 0 {
+		return nil, p.errorf(first, "expected command")
+	}
+	cmd := &commandNode{verb: strings.Join(words, " ")}
+	if p.peekWord("where") {
+		p.next()
+		for {
+			arg, argEnd, err := p.arg()
+			if err != nil {
+				return nil, err
+			}
+			cmd.args = append(cmd.args, arg)
+			end = argEnd
+			if !p.peekWord("and") {
+				break
+			}
+			p.next()
+		}
+	}
+	cmd.nodeSpan = nodeSpan{first.pos, end}
+	return cmd, nil
+}
+
+// arg parses one where-clause argument and returns it with its end offset.
+func (p *queryParser) arg() (argNode, int, error) {
+	t := p.next()
+	switch t.kind {
+	case tokVariable:
+		return argNode{op: "=", value: valueNode{valueVariable, t.text}}, t.end, nil
+	case tokWord:
+	default:
+		return argNode{}, 0, p.errorf(t, "expected argument name")
+	}
+	arg := argNode{name: t.text}
+	op := p.next()
+	switch {
+	case op.kind == tokOp:
+		arg.op = op.text
+	case op.kind == tokWord && strings.EqualFold(op.text, "like"):
+		arg.op = "like"
+	case op.kind == tokWord && strings.EqualFold(op.text, "not") && p.peekWord("like"):
+		p.next()
+		arg.op = "not like"
+	case op.kind == tokWord && strings.EqualFold(op.text, "is"):
+		arg.op = "is null"
+		if p.peekWord("not") {
+			p.next()
+			arg.op = "is not null"
+		}
+		null := p.next()
+		if null.kind != tokWord || !strings.EqualFold(null.text, "null") {
+			return argNode{}, 0, p.errorf(null, "expected null")
+		}
+		return arg, null.end, nil
+	default:
+		return argNode{}, 0, p.errorf(op, "expected operator after %q", arg.name)
+	}
+	v := p.next()
+	switch v.kind {
+	case tokString:
+		arg.value = valueNode{valueString, v.text}
+	case tokWord:
+		arg.value = valueNode{valueWord, v.text}
+	case tokVariable:
+		arg.value = valueNode{valueVariable, v.text}
+	default:
+		return argNode{}, 0, p.errorf(v, "expected value for %q", arg.name)
+	}
+	return arg, v.end, nil
+}
+
+// parseCommand parses q and returns it if it is a single command.
+func parseCommand(q string) (*commandNode, bool) {
+	node, err := parseQuery(q)
+	if err != nil {
+		return nil, false
+	}
+	cmd, ok := node.(*commandNode)
+	return cmd, ok
+}
+
+// equalArgs returns the name = value arguments of cmd.
+func (cmd *commandNode) equalArgs() map[string]string {
+	args := make(map[string]string)
+	for _, a := range cmd.args {
+		if a.name != "" && a.op == "=" {
+			args[a.name] = a.value.text
+		}
+	}
+	return args
+}
@@ -0,0 +1,138 @@
+package mocka
+
+import (
+	"log/slog"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseQuery(t *testing.T) {
+
+	Convey("parseQuery", t, func() {
+
+		Convey("parses a command with where-clause arguments", func() {
+			node, err := parseQuery(`list warehouses where wh_id = 'MHE' and descr = "a and b" and qty >= 10 and loc like 'A%' and lodnum is not null and @*`)
+			So(err, ShouldBeNil)
+			cmd, ok := node.(*commandNode)
+			So(ok, ShouldBeTrue)
+			So(cmd.verb, ShouldEqual, "list warehouses")
+			So(cmd.args, ShouldResemble, []argNode{
+				{name: "wh_id", op: "=", value: valueNode{valueString, "MHE"}},
+				{name: "descr", op: "=", value: valueNode{valueString, "a and b"}},
+				{name: "qty", op: ">=", value: valueNode{valueWord, "10"}},
+				{name: "loc", op: "like", value: valueNode{valueString, "A%"}},
+				{name: "lodnum", op: "is not null"},
+				{op: "=", value: valueNode{valueVariable, "*"}},
+			})
+		})
+
+		Convey("accepts operators spaced out by normalizeQuery", func() {
+			cmd, ok := parseCommand(normalizeQuery("list things where a!=1 and b<=2 and c<>3"))
+			So(ok, ShouldBeTrue)
+			So(cmd.args[0].op, ShouldEqual, "!=")
+			So(cmd.args[1].op, ShouldEqual, "<=")
+			So(cmd.args[2].op, ShouldEqual, "!=")
+		})
+
+		Convey("treats doubled quotes as escapes", func() {
+			cmd, ok := parseCommand(`say where msg = 'it''s'`)
+			So(ok, ShouldBeTrue)
+			So(cmd.args[0].value.text, ShouldEqual, "it's")
+		})
+
+		Convey("parses pipes, blocks, sequences and operators with MOCA precedence", func() {
+			q := "publish data where a = 1 | { list things ; [select 1 from dual] } & list other || [[ x = 1 ]]"
+			node, err := parseQuery(q)
+			So(err, ShouldBeNil)
+
+			or, ok := node.(*binaryNode)
+			So(ok, ShouldBeTrue)
+			So(or.op, ShouldEqual, "||")
+			So(or.right.(*groovyNode).text, ShouldEqual, " x = 1 ")
+
+			and := or.left.(*binaryNode)
+			So(and.op, ShouldEqual, "&")
+			So(and.right.(*commandNode).verb, ShouldEqual, "list other")
+
+			pipe := and.left.(*pipelineNode)
+			So(pipe.stages, ShouldHaveLength, 2)
+			So(pipe.stages[0].(*commandNode).verb, ShouldEqual, "publish data")
+			block := pipe.stages[1].(*blockNode)
+			So(block.source(q), ShouldEqual, "{ list things ; [select 1 from dual] }")
+			seq := block.body.(*sequenceNode)
+			So(seq.stmts, ShouldHaveLength, 2)
+			So(seq.stmts[1].(*sqlNode).text, ShouldEqual, "select 1 from dual")
+		})
+
+		Convey("keeps quoted delimiters inside values and SQL", func() {
+			node, err := parseQuery(`publish data where x = 'a | { b } ; c' | { [select '}]' from dual] }`)
+			So(err, ShouldBeNil)
+			ctx, inner, ok := flattenPublishData(node)
+			So(ok, ShouldBeTrue)
+			So(ctx["x"], ShouldEqual, "a | { b } ; c")
+			So(inner.(*sqlNode).text, ShouldEqual, "select '}]' from dual")
+		})
+
+		Convey("reports syntax errors with their offset", func() {
+			for q, msg := range map[string]string{
+				"":                          "syntax error at offset 0: unexpected end of query",
+				"list things where":         "syntax error at offset 17: expected argument name",
+				"list things where a 1":     "syntax error at offset 20: expected operator after \"a\"",
+				"publish data | { x":        "syntax error at offset 18: expected } to close block at offset 15",
+				"say where msg = 'oops":     "syntax error at offset 16: unterminated string",
+				"[select 1 from dual":       "syntax error at offset 0: unterminated sql block",
+				"[[ x = 1 ]":                "syntax error at offset 0: unterminated groovy block",
+				"list things }":             "syntax error at offset 12: unexpected \"}\"",
+				"list things where a = | b": "syntax error at offset 22: expected value for \"a\"",
+			} {
+				_, err := parseQuery(q)
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldEqual, msg)
+			}
+		})
+	})
+}
+
+func TestMatchQuery_QuotedDelimiters(t *testing.T) {
+
+	Convey("Given a publish_data entry", t, func() {
+		entries := []Entry{{
+			MatchType:  MatchTypePublishData,
+			Inner:      normalizeQuery("do thing"),
+			Context:    map[string]string{"descr": "a and b | { c }"},
+			StatusCode: StatusOK,
+		}}
+
+		Convey("context values may contain 'and', pipes and braces inside quotes", func() {
+			r := matchQuery(normalizeQuery("publish data where descr = 'a and b | { c }' | { do thing }"), entries, slog.Default())
+			So(r.StatusCode, ShouldEqual, StatusOK)
+		})
+	})
+}
+
+func FuzzParseQuery(f *testing.F) {
+	for _, seed := range []string{
+		"ping",
+		"login user where usr_id = 'super' and usr_pswd = 'secret'",
+		"publish data where wh_id = 'MHE' and a = \"x | y\" | { list inventory where wh_id = @wh_id }",
+		"list things where a != 1 and b is not null and @* ; [select * from dual where x = ']' ] & [[ println 'hi' ]]",
+		"{ a || b && c } | d",
+		"list things where a = 'it''s'",
+		"publish data | { x",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, q string) {
+		node, err := parseQuery(q)
+		if err == nil {
+			if s := node.span(); s.pos < 0 || s.pos > s.end || s.end > len(q) {
+				t.Fatalf("span %v out of range for %q", s, q)
+			}
+		}
+		normalized := normalizeQuery(q)
+		parseQuery(normalized)
+		parsePublishData(normalized, NormalizationLossy)
+		loginInnerQuery(q)
+	})
+}
@@ -49,6 +49,14 @@ func TestNormalizeQuery(t *testing.T) {
 					normalizeQuery(`list things where a = "foo" and b = "bar"`),
 				)
 			})
+
+			Convey("apostrophes inside double-quoted values are escaped when requoted", func() {
+				So(normalizeQuery(`login user where usr_pswd = "it's"`), ShouldEqual, "login user where usr_pswd = 'it''s'")
+			})
+
+			Convey("double quotes inside single-quoted values are kept", func() {
+				So(normalizeQuery(`publish data where s = 'say "hi"'`), ShouldEqual, `publish data where s = 'say "hi"'`)
+			})
 		})
 
 		Convey("embedded SQL — bracket syntax [...]", func() {
@@ -90,3 +98,55 @@ func TestNormalizeQuery(t *testing.T) {
 		})
 	})
 }
+
+func TestNormalizeLiteralQuery(t *testing.T) {
+
+	Convey("normalizeLiteralQuery", t, func() {
+
+		Convey("normalizes keywords, whitespace and = spacing outside literals", func() {
+			So(normalizeLiteralQuery("  LIST  Items\nWHERE descr='x'  "), ShouldEqual, "list items where descr = 'x'")
+		})
+
+		Convey("preserves the case and spacing of string literals", func() {
+			So(normalizeLiteralQuery("list items where descr = 'A=B'"), ShouldEqual, "list items where descr = 'A=B'")
+			So(normalizeLiteralQuery("list items where descr = 'a = b'"), ShouldEqual, "list items where descr = 'a = b'")
+			So(normalizeLiteralQuery("list items where descr = 'Two  Spaces'"), ShouldEqual, "list items where descr = 'Two  Spaces'")
+		})
+
+		Convey("requotes double-quoted local syntax literals", func() {
+			So(normalizeLiteralQuery(`list items where descr = "Mixed Case"`), ShouldEqual, "list items where descr = 'Mixed Case'")
+		})
+
+		Convey("keeps escaped quotes inside literals", func() {
+			So(normalizeLiteralQuery("publish data where s = 'It''s'"), ShouldEqual, "publish data where s = 'It''s'")
+			So(normalizeLiteralQuery(`publish data where s = "It's"`), ShouldEqual, "publish data where s = 'It''s'")
+			So(normalizeLiteralQuery(`publish data where s = "Say ""Hi"""`), ShouldEqual, `publish data where s = 'Say "Hi"'`)
+		})
+
+		Convey("normalizes SQL and Groovy blocks but not their literals", func() {
+			So(normalizeLiteralQuery("[ SELECT *\n FROM dual WHERE x='A b' ]"), ShouldEqual, "[select * from dual where x = 'A b']")
+			So(normalizeLiteralQuery(`[[ println "Hello" ]]`), ShouldEqual, `[[println "Hello"]]`)
+		})
+
+		Convey("folds to the lossy form under normalizeQuery", func() {
+			for _, q := range []string{
+				"list items where descr = 'A=B' and x=1",
+				`publish data where a = "Foo" | { list things where b = 'It''s' }`,
+				"[ select a,b from t where x = 'A b' ]",
+				"[[ println 'Hi' ]] ; ping",
+				`login user where usr_pswd = "It's" and s = 'a "b"'`,
+				`publish data where s = "Say ""Hi"""`,
+			} {
+				So(normalizeQuery(normalizeLiteralQuery(q)), ShouldEqual, normalizeQuery(q))
+			}
+		})
+	})
+
+	Convey("ParseNormalizationMode", t, func() {
+		mode, err := ParseNormalizationMode("literal")
+		So(err, ShouldBeNil)
+		So(mode, ShouldEqual, NormalizationLiteral)
+		_, err = ParseNormalizationMode("exact")
+		So(err, ShouldNotBeNil)
+	})
+}
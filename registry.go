@@ -1,30 +1,69 @@
 package mocka
 
 import (
+	"fmt"
 	"log/slog"
+	"slices"
 )
 
 // --- Registry ---
 
 // ResponseLookup resolves queries to canned responses using a ResponseLoader.
 type ResponseLookup struct {
-	entries []Entry
-	logger  *slog.Logger
+	entries   []Entry
+	overrides []Entry // Builtin entries; consulted only for built-in commands
+	index     *matchIndex
+	logger    *slog.Logger
 }
 
 // NewResponseLookup creates a ResponseLookup by loading entries from loader.
+// Entries are ordered by descending Priority, keeping load order among
+// equal priorities, and indexed for matching. Every result set is parsed
+// once here; an entry whose result set is not valid <moca-results> XML
+// fails construction.
 func NewResponseLookup(loader ResponseLoader) (*ResponseLookup, error) {
 	entries, err := loader.Load()
 	if err != nil {
 		return nil, err
 	}
-	return &ResponseLookup{
-		entries: entries,
-		logger:  slog.Default(),
-	}, nil
+	r := &ResponseLookup{logger: slog.Default()}
+	for i, e := range entries {
+		if err := e.prepare(); err != nil {
+			return nil, fmt.Errorf("entry %d (%s): parsing result set: %w", i+1, e.describe(), err)
+		}
+		if e.Builtin {
+			r.overrides = append(r.overrides, e)
+		} else {
+			r.entries = append(r.entries, e)
+		}
+	}
+	r.entries = sortByPriority(r.entries)
+	r.overrides = sortByPriority(r.overrides)
+	r.index = newMatchIndex(r.entries)
+	if slices.ContainsFunc(r.entries, func(e Entry) bool { return e.Priority != 0 }) {
+		for i, e := range r.entries {
+			r.logger.Debug("match order", "position", i+1, "priority", e.Priority, "entry", e.describe())
+		}
+	}
+	return r, nil
 }
 
-// GetResponse returns the matching response for the already-normalized query string.
+// GetResponse returns the matching response for the query string, which may
+// be raw or normalized in either NormalizationMode; entries normalized with
+// NormalizationLiteral see literal contents unless it was normalized
+// with NormalizationLossy. Line comments in Groovy scripts are only ignored
+// in a raw query.
 func (r *ResponseLookup) GetResponse(query string) Response {
-	return matchQuery(query, r.entries, r.logger)
+	r.logger.Debug("matching query", "query", query)
+	if resp, ok := r.index.match(query, r.logger); ok {
+		return resp
+	}
+	return noMatch(query, r.logger)
+}
+
+// GetOverride returns the response registered to override a built-in command
+// (ping, login user, logout user) for the already-normalized query, and
+// whether one was found.
+func (r *ResponseLookup) GetOverride(query string) (Response, bool) {
+	return findMatch(query, r.overrides, r.logger)
 }
@@ -1,5 +1,12 @@
 package mocka
 
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/castingcode/mocaprotocol"
+)
+
 const (
 	StatusOK                = 0
 	StatusSrvNoDataFound    = 510
@@ -17,13 +24,19 @@ const (
 	MatchTypeExact       MatchType = "exact"
 	MatchTypePublishData MatchType = "publish_data"
 	MatchTypePrefix      MatchType = "prefix"
+	MatchTypeSQL         MatchType = "sql"
+	MatchTypeGroovy      MatchType = "groovy"
 )
 
 // Response is the runtime result returned by the matcher, containing the mocked result data.
 type Response struct {
-	StatusCode int
-	Message    string
-	ResultSet  string
+	StatusCode     int
+	Message        string
+	ResultSet      string
+	RequireSession bool // only consulted in SessionModeEntry
+
+	lazy   *lazyResultSet   // generates ResultSet on first use when set
+	parsed *parsedResultSet // set for responses matched from a ResponseLookup
 }
 
 // Entry is a fully resolved, normalized response entry ready for matching.
@@ -33,15 +46,91 @@ type Entry struct {
 	Inner      string            // normalized; used for publish_data match
 	Context    map[string]string // normalized values; used for publish_data contextual match
 	Prefix     string            // normalized; used for prefix match
+	SQL        string            // canonical statement; used for sql match
+	Table      string            // normalized; used for sql match
+	Columns    []string          // canonical; used for sql match
 	StatusCode int
 	Message    string
 	ResultSet  string // pre-loaded XML content
+	// RequireSession marks the entry as requiring a valid session key when
+	// the handler runs in SessionModeEntry (YAML auth: required).
+	RequireSession bool
+	// Builtin marks the entry as an override for a built-in command (ping,
+	// login user, logout user). Builtin entries are only consulted for those
+	// commands and never for ordinary queries (YAML match.builtin).
+	Builtin bool
+	// Normalization is the mode Query, Inner, Context and Prefix were
+	// normalized with; incoming queries are compared in the same mode. The
+	// zero value is NormalizationLossy (YAML match.normalization).
+	Normalization NormalizationMode
+	// Fingerprint, Snippets and Patterns are used for groovy match:
+	// Fingerprint is a GroovyFingerprint, Snippets are canonical script
+	// fragments and Patterns match the canonical script text.
+	Fingerprint string
+	Snippets    []string
+	Patterns    []*regexp.Regexp
+	// Args are predicates on the query's where-clause arguments and publish
+	// data context, keyed by lowercased argument name. An entry with Args
+	// only matches queries that satisfy all of them (YAML match.args).
+	Args map[string]ArgPredicate
+	// Priority orders entries across match types: entries with a higher
+	// priority are tried first, through the whole matching hierarchy. Ties
+	// are broken by the hierarchy and then load order. The zero value is the
+	// default; a negative priority makes a last-resort fallback (YAML
+	// match.priority).
+	Priority int
+
+	lazy   *lazyResultSet   // generates ResultSet on first use when set
+	parsed *parsedResultSet // set by NewResponseLookup
+}
+
+// describe identifies e by its match type and pattern for error messages.
+func (e *Entry) describe() string {
+	switch e.MatchType {
+	case MatchTypeExact:
+		return fmt.Sprintf("exact %q", e.Query)
+	case MatchTypePublishData:
+		return fmt.Sprintf("publish_data %q", e.Inner)
+	case MatchTypePrefix:
+		return fmt.Sprintf("prefix %q", e.Prefix)
+	case MatchTypeSQL:
+		if e.SQL != "" {
+			return fmt.Sprintf("sql %q", e.SQL)
+		}
+		return fmt.Sprintf("sql table %q", e.Table)
+	case MatchTypeGroovy:
+		if e.Fingerprint != "" {
+			return fmt.Sprintf("groovy %s", e.Fingerprint)
+		}
+		return fmt.Sprintf("groovy %q", e.Snippets)
+	}
+	return string(e.MatchType)
 }
 
 func (e *Entry) response() Response {
 	return Response{
-		StatusCode: e.StatusCode,
-		Message:    e.Message,
-		ResultSet:  e.ResultSet,
+		StatusCode:     e.StatusCode,
+		Message:        e.Message,
+		ResultSet:      e.ResultSet,
+		RequireSession: e.RequireSession,
+		lazy:           e.lazy,
+		parsed:         e.parsed,
+	}
+}
+
+// resultSet returns the response's result set XML, generating it first if
+// it is produced lazily.
+func (r Response) resultSet() (string, error) {
+	if r.lazy != nil {
+		return r.lazy.get()
+	}
+	return r.ResultSet, nil
+}
+
+// results returns the response's parsed result set, or nil if it has none.
+func (r Response) results() (*mocaprotocol.MocaResults, error) {
+	if r.parsed != nil {
+		return r.parsed.get()
 	}
+	return parseResultSet(r.resultSet)
 }
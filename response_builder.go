@@ -9,9 +9,11 @@ import (
 // ResponseBuilder constructs a Response for use with InMemoryResponseLoader
 // options. Use NewResponse to obtain a builder.
 type ResponseBuilder struct {
-	statusCode int
-	message    string
-	resultSet  string
+	statusCode     int
+	message        string
+	resultSet      string
+	requireSession bool
+	lazy           *lazyResultSet
 }
 
 // NewResponse returns a ResponseBuilder for the given HTTP/MOCA status code.
@@ -42,11 +44,53 @@ func (b *ResponseBuilder) WithResultSetFromFile(path string) (*ResponseBuilder,
 	return b, nil
 }
 
+// WithResultSetBuilder sets the result set from a typed ResultSetBuilder.
+// Returns an error if the builder recorded one.
+func (b *ResponseBuilder) WithResultSetBuilder(rs *ResultSetBuilder) (*ResponseBuilder, error) {
+	results, err := rs.Build()
+	if err != nil {
+		return nil, fmt.Errorf("building result set: %w", err)
+	}
+	xml, err := marshalResults(results)
+	if err != nil {
+		return nil, fmt.Errorf("encoding result set: %w", err)
+	}
+	b.resultSet = xml
+	return b, nil
+}
+
+// WithStructs sets the result set from a slice of structs. See
+// NewResultSetFromStructs for the moca tag format.
+func (b *ResponseBuilder) WithStructs(rows any) (*ResponseBuilder, error) {
+	return b.WithResultSetBuilder(NewResultSetFromStructs(rows))
+}
+
+// WithSyntheticResultSet sets the result set to rows generated from spec.
+// Generation happens now, or on the first matching request if spec.Lazy is
+// set. Returns an error if the spec is invalid.
+func (b *ResponseBuilder) WithSyntheticResultSet(spec SyntheticSpec) (*ResponseBuilder, error) {
+	xml, lazy, err := spec.resultSet()
+	if err != nil {
+		return nil, fmt.Errorf("generating result set: %w", err)
+	}
+	b.resultSet, b.lazy = xml, lazy
+	return b, nil
+}
+
+// RequireSession marks the response as requiring a valid session key when
+// the handler runs in SessionModeEntry. It has no effect in other modes.
+func (b *ResponseBuilder) RequireSession() *ResponseBuilder {
+	b.requireSession = true
+	return b
+}
+
 // Build returns the constructed Response.
 func (b *ResponseBuilder) Build() Response {
 	return Response{
-		StatusCode: b.statusCode,
-		Message:    b.message,
-		ResultSet:  b.resultSet,
+		StatusCode:     b.statusCode,
+		Message:        b.message,
+		ResultSet:      b.resultSet,
+		RequireSession: b.requireSession,
+		lazy:           b.lazy,
 	}
 }
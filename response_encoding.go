@@ -0,0 +1,149 @@
+package mocka
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/castingcode/mocaprotocol"
+)
+
+// parsedResultSet caches an entry's result set parsed into
+// mocaprotocol.MocaResults, so the XML is parsed once rather than on every
+// request. It is shared by every Response copied from the entry.
+type parsedResultSet struct {
+	once    sync.Once
+	source  func() (string, error)
+	results *mocaprotocol.MocaResults
+	err     error
+}
+
+func (p *parsedResultSet) get() (*mocaprotocol.MocaResults, error) {
+	p.once.Do(func() {
+		p.results, p.err = parseResultSet(p.source)
+	})
+	return p.results, p.err
+}
+
+// prepare attaches a result set cache to e. Eager result sets are parsed
+// immediately and a parse error is returned; lazy ones are parsed on first
+// use.
+func (e *Entry) prepare() error {
+	resp := e.response()
+	e.parsed = &parsedResultSet{source: resp.resultSet}
+	if e.lazy != nil {
+		return nil
+	}
+	_, err := e.parsed.get()
+	return err
+}
+
+// parseResultSet parses the XML returned by source. It returns nil if
+// there is no result set.
+func parseResultSet(source func() (string, error)) (*mocaprotocol.MocaResults, error) {
+	resultSet, err := source()
+	if err != nil || resultSet == "" {
+		return nil, err
+	}
+	var results mocaprotocol.MocaResults
+	if err := xml.Unmarshal([]byte(resultSet), &results); err != nil {
+		return nil, err
+	}
+	return &results, nil
+}
+
+// ResponseEncoder writes MOCA responses in one wire encoding.
+// MocaRequestHandler picks the encoder whose ContentType the request's
+// Accept header names, falling back to XMLEncoder.
+type ResponseEncoder interface {
+	// ContentType is the media type the encoder produces.
+	ContentType() string
+	// Encode writes response to w.
+	Encode(w io.Writer, response mocaprotocol.MocaResponse) error
+}
+
+// XMLEncoder streams responses as MOCA XML documents. It is the default
+// encoding.
+type XMLEncoder struct{}
+
+func (XMLEncoder) ContentType() string { return "application/moca-xml" }
+
+func (XMLEncoder) Encode(w io.Writer, response mocaprotocol.MocaResponse) error {
+	if _, err := w.Write(XMLDeclaration); err != nil {
+		return err
+	}
+	return xml.NewEncoder(w).Encode(response)
+}
+
+// CompressedXMLEncoder writes the MOCA XML document gzip-compressed and
+// base64-encoded, the payload variant some MOCA clients request.
+type CompressedXMLEncoder struct{}
+
+func (CompressedXMLEncoder) ContentType() string { return "application/moca-compressed" }
+
+func (CompressedXMLEncoder) Encode(w io.Writer, response mocaprotocol.MocaResponse) error {
+	b64 := base64.NewEncoder(base64.StdEncoding, w)
+	zw := gzip.NewWriter(b64)
+	if err := (XMLEncoder{}).Encode(zw, response); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return b64.Close()
+}
+
+// WithResponseEncoders registers additional response encodings, selectable
+// by the request's Accept header. XMLEncoder and CompressedXMLEncoder are
+// always available; an encoder with the same ContentType replaces them.
+func WithResponseEncoders(encoders ...ResponseEncoder) MocaRequestHandlerOption {
+	return func(h *MocaRequestHandler) {
+		for _, enc := range encoders {
+			h.encoders[strings.ToLower(enc.ContentType())] = enc
+		}
+	}
+}
+
+// negotiateEncoder returns the registered encoder preferred by an Accept
+// header, or XMLEncoder if none is named.
+func (h *MocaRequestHandler) negotiateEncoder(accept string) ResponseEncoder {
+	var (
+		best  ResponseEncoder = XMLEncoder{}
+		bestQ float64
+	)
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		enc, ok := h.encoders[strings.ToLower(strings.TrimSpace(mediaType))]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+// mocaResponseWriter is an http.ResponseWriter that also carries the
+// ResponseEncoder negotiated for the request.
+type mocaResponseWriter struct {
+	http.ResponseWriter
+	encoder ResponseEncoder
+}
+
+// writeMocaResponse writes response to w with its negotiated encoder.
+func writeMocaResponse(w *mocaResponseWriter, response mocaprotocol.MocaResponse) error {
+	w.Header().Set("Content-Type", w.encoder.ContentType())
+	return w.encoder.Encode(w, response)
+}
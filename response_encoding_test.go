@@ -0,0 +1,133 @@
+package mocka
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/castingcode/mocaprotocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestResponseEncoders(t *testing.T) {
+
+	Convey("Response encoders", t, func() {
+		response := mocaprotocol.MocaResponse{Status: StatusOK, Message: "ok"}
+		So(xml.Unmarshal([]byte(`<moca-results><metadata><column name="wh_id" type="S"/></metadata><data><row><field>MHE</field></row></data></moca-results>`), &response.MocaResults), ShouldBeNil)
+		want := marshalMocaResponse(response)
+
+		Convey("XMLEncoder writes the same bytes as marshalling the envelope", func() {
+			var buf bytes.Buffer
+			So(XMLEncoder{}.Encode(&buf, response), ShouldBeNil)
+			So(buf.String(), ShouldEqual, string(want))
+		})
+
+		Convey("CompressedXMLEncoder writes the XML gzipped and base64-encoded", func() {
+			var buf bytes.Buffer
+			So(CompressedXMLEncoder{}.Encode(&buf, response), ShouldBeNil)
+
+			zr, err := gzip.NewReader(base64.NewDecoder(base64.StdEncoding, &buf))
+			So(err, ShouldBeNil)
+			got, err := io.ReadAll(zr)
+			So(err, ShouldBeNil)
+			So(string(got), ShouldEqual, string(want))
+		})
+	})
+}
+
+// upperEncoder is a test ResponseEncoder that writes the status message in
+// upper case.
+type upperEncoder struct{}
+
+func (upperEncoder) ContentType() string { return "text/x-upper" }
+
+func (upperEncoder) Encode(w io.Writer, response mocaprotocol.MocaResponse) error {
+	_, err := io.WriteString(w, strings.ToUpper(response.Message))
+	return err
+}
+
+func TestHandleMocaRequest_ResponseEncoding(t *testing.T) {
+
+	Convey("Given a MocaRequestHandler", t, func() {
+		lookup, err := NewResponseLookup(NewInMemoryResponseLoader(
+			WithExactMatch("list warehouses", NewResponse(StatusOK).WithMessage("ok").Build()),
+		))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mux := http.NewServeMux()
+		RegisterRoutes(mux, NewMocaRequestHandler(lookup,
+			WithSessionMode(SessionModeDisabled),
+			WithResponseEncoders(upperEncoder{}),
+		))
+		send := func(command, accept string) *httptest.ResponseRecorder {
+			req := buildRequest(t, command)
+			if accept != "" {
+				req.Header.Set("Accept", accept)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+			return w
+		}
+
+		Convey("responses are XML by default", func() {
+			w := send("list warehouses", "*/*")
+			So(w.Header().Get("Content-Type"), ShouldEqual, "application/moca-xml")
+			So(w.Body.String(), ShouldStartWith, string(XMLDeclaration))
+		})
+
+		Convey("the Accept header selects the compressed encoding", func() {
+			w := send("ping", "application/moca-compressed")
+			So(w.Header().Get("Content-Type"), ShouldEqual, "application/moca-compressed")
+			zr, err := gzip.NewReader(base64.NewDecoder(base64.StdEncoding, w.Body))
+			So(err, ShouldBeNil)
+			got, _ := io.ReadAll(zr)
+			So(string(got), ShouldEqual, string(marshalMocaResponse(generatePingResponse())))
+		})
+
+		Convey("registered encoders are selected by quality", func() {
+			w := send("list warehouses", "application/moca-xml;q=0.5, text/x-upper")
+			So(w.Header().Get("Content-Type"), ShouldEqual, "text/x-upper")
+			So(w.Body.String(), ShouldEqual, "OK")
+		})
+	})
+}
+
+func TestEntryPrepare(t *testing.T) {
+
+	Convey("Entry.prepare", t, func() {
+
+		Convey("parses an eager result set once and shares it across responses", func() {
+			e := newEntry(MatchTypeExact, NewResponse(StatusOK).WithResultSet(`<moca-results><metadata/><data/></moca-results>`).Build())
+			e.prepare()
+			So(e.parsed.results, ShouldNotBeNil)
+
+			a, err := e.response().results()
+			So(err, ShouldBeNil)
+			b, _ := e.response().results()
+			So(a, ShouldPointTo, b)
+		})
+
+		Convey("defers a lazy result set to first use", func() {
+			rb, err := NewResponse(StatusOK).WithSyntheticResultSet(SyntheticSpec{
+				Rows:    2,
+				Lazy:    true,
+				Columns: []SyntheticColumn{{Name: "id", Generator: "sequence"}},
+			})
+			So(err, ShouldBeNil)
+			e := newEntry(MatchTypeExact, rb.Build())
+			e.prepare()
+			So(e.parsed.results, ShouldBeNil)
+
+			results, err := e.response().results()
+			So(err, ShouldBeNil)
+			So(results.Data.Rows, ShouldHaveLength, 2)
+		})
+	})
+}
@@ -1,7 +1,9 @@
 package mocka
 
 import (
-	"strings"
+	"log/slog"
+	"maps"
+	"regexp"
 )
 
 // InMemoryResponseLoaderOption configures an InMemoryResponseLoader.
@@ -11,7 +13,9 @@ type InMemoryResponseLoaderOption func(*InMemoryResponseLoader)
 // It is intended for use by projects that import mocka as a test dependency
 // and need to register canned responses programmatically alongside httptest.
 type InMemoryResponseLoader struct {
-	entries []Entry
+	entries       []Entry
+	validation    ValidationLevel
+	normalization NormalizationMode // for entries appended by later options
 }
 
 var _ ResponseLoader = (*InMemoryResponseLoader)(nil)
@@ -20,7 +24,7 @@ var _ ResponseLoader = (*InMemoryResponseLoader)(nil)
 // the given options. Options are applied in order; all entry-producing options
 // append to the entry list.
 func NewInMemoryResponseLoader(opts ...InMemoryResponseLoaderOption) *InMemoryResponseLoader {
-	l := &InMemoryResponseLoader{}
+	l := &InMemoryResponseLoader{validation: ValidationWarn}
 	for _, opt := range opts {
 		opt(l)
 	}
@@ -29,9 +33,43 @@ func NewInMemoryResponseLoader(opts ...InMemoryResponseLoaderOption) *InMemoryRe
 
 // Load implements ResponseLoader by returning the in-memory entries.
 func (l *InMemoryResponseLoader) Load() ([]Entry, error) {
+	if err := validateEntries(l.entries, l.validation, slog.Default()); err != nil {
+		return nil, err
+	}
 	return l.entries, nil
 }
 
+// WithValidation sets how result sets that violate MOCA type semantics are
+// treated by Load. The default is ValidationWarn.
+func WithValidation(level ValidationLevel) InMemoryResponseLoaderOption {
+	return func(l *InMemoryResponseLoader) {
+		l.validation = level
+	}
+}
+
+// WithNormalization sets how the query patterns of entries appended by later
+// options are normalized. Pass it before any entry-producing option to
+// select a mode for the whole loader. The default is NormalizationLossy.
+// WithEntries is unaffected; pre-built entries carry their own mode.
+func WithNormalization(mode NormalizationMode) InMemoryResponseLoaderOption {
+	return func(l *InMemoryResponseLoader) {
+		l.normalization = mode
+	}
+}
+
+// WithNormalizedMatch normalizes the entries appended by opt with mode,
+// regardless of the loader-wide mode, for example:
+//
+//	WithNormalizedMatch(NormalizationLiteral, WithExactMatch("list items where descr = 'A=B'", resp))
+func WithNormalizedMatch(mode NormalizationMode, opt InMemoryResponseLoaderOption) InMemoryResponseLoaderOption {
+	return func(l *InMemoryResponseLoader) {
+		saved := l.normalization
+		l.normalization = mode
+		opt(l)
+		l.normalization = saved
+	}
+}
+
 // WithEntries appends a pre-built slice of entries to the loader.
 func WithEntries(entries []Entry) InMemoryResponseLoaderOption {
 	return func(l *InMemoryResponseLoader) {
@@ -43,13 +81,9 @@ func WithEntries(entries []Entry) InMemoryResponseLoaderOption {
 // The query is normalized before storage.
 func WithExactMatch(query string, resp Response) InMemoryResponseLoaderOption {
 	return func(l *InMemoryResponseLoader) {
-		l.entries = append(l.entries, Entry{
-			MatchType:  MatchTypeExact,
-			Query:      normalizeQuery(query),
-			StatusCode: resp.StatusCode,
-			Message:    resp.Message,
-			ResultSet:  resp.ResultSet,
-		})
+		e := l.entry(MatchTypeExact, resp)
+		e.Query = normalizeQueryMode(query, e.Normalization)
+		l.entries = append(l.entries, e)
 	}
 }
 
@@ -57,13 +91,9 @@ func WithExactMatch(query string, resp Response) InMemoryResponseLoaderOption {
 // The prefix is normalized before storage.
 func WithPrefixMatch(prefix string, resp Response) InMemoryResponseLoaderOption {
 	return func(l *InMemoryResponseLoader) {
-		l.entries = append(l.entries, Entry{
-			MatchType:  MatchTypePrefix,
-			Prefix:     normalizeQuery(prefix),
-			StatusCode: resp.StatusCode,
-			Message:    resp.Message,
-			ResultSet:  resp.ResultSet,
-		})
+		e := l.entry(MatchTypePrefix, resp)
+		e.Prefix = normalizeQueryMode(prefix, e.Normalization)
+		l.entries = append(l.entries, e)
 	}
 }
 
@@ -73,35 +103,145 @@ func WithPrefixMatch(prefix string, resp Response) InMemoryResponseLoaderOption
 // The inner command is normalized before storage.
 func WithPublishDataMatch(inner string, resp Response) InMemoryResponseLoaderOption {
 	return func(l *InMemoryResponseLoader) {
-		l.entries = append(l.entries, Entry{
-			MatchType:  MatchTypePublishData,
-			Inner:      normalizeQuery(inner),
-			StatusCode: resp.StatusCode,
-			Message:    resp.Message,
-			ResultSet:  resp.ResultSet,
-		})
+		e := l.entry(MatchTypePublishData, resp)
+		e.Inner = normalizeQueryMode(inner, e.Normalization)
+		l.entries = append(l.entries, e)
 	}
 }
 
 // WithContextualPublishDataMatch appends a contextual publish-data entry that
 // only matches when the incoming query carries all of the specified context
 // key/value pairs. Context values are lowercased for consistent comparison
-// with the normalized incoming query.
+// with the normalized incoming query, unless the entry is normalized with
+// NormalizationLiteral.
 // The inner command is normalized before storage.
 func WithContextualPublishDataMatch(inner string, context map[string]string, resp Response) InMemoryResponseLoaderOption {
-	normalized := make(map[string]string, len(context))
-	for k, v := range context {
-		normalized[k] = strings.ToLower(v)
+	return func(l *InMemoryResponseLoader) {
+		e := l.entry(MatchTypePublishData, resp)
+		e.Inner = normalizeQueryMode(inner, e.Normalization)
+		e.Context = normalizeContext(maps.Clone(context), e.Normalization)
+		l.entries = append(l.entries, e)
+	}
+}
+
+// WithSQLMatch appends an sql entry matching embedded SQL statements that
+// equal sql after canonicalization: keyword case, spacing, bind variables
+// and the order of top-level AND predicates in the WHERE clause do not
+// matter. A "?" in sql matches any literal or bind variable. The brackets
+// around sql are optional.
+func WithSQLMatch(sql string, resp Response) InMemoryResponseLoaderOption {
+	return func(l *InMemoryResponseLoader) {
+		e := l.entry(MatchTypeSQL, resp)
+		e.setSQLPattern(sql, "", nil)
+		l.entries = append(l.entries, e)
+	}
+}
+
+// WithSQLTableMatch appends an sql entry matching embedded SQL statements
+// that read from table and select at least the given columns, in any order.
+// A column matches by its full expression or by its alias or final
+// identifier, so "wh_id" matches "w.wh_id". Either table or columns may be
+// empty.
+func WithSQLTableMatch(table string, columns []string, resp Response) InMemoryResponseLoaderOption {
+	return func(l *InMemoryResponseLoader) {
+		e := l.entry(MatchTypeSQL, resp)
+		e.setSQLPattern("", table, columns)
+		l.entries = append(l.entries, e)
+	}
+}
+
+// WithGroovyMatch appends a groovy entry matching embedded Groovy scripts
+// with the same GroovyFingerprint as script: comments, whitespace, string
+// quoting, semicolons and the names of def variables do not matter. The
+// brackets around script are optional.
+func WithGroovyMatch(script string, resp Response) InMemoryResponseLoaderOption {
+	return func(l *InMemoryResponseLoader) {
+		e := l.entry(MatchTypeGroovy, resp)
+		e.setGroovyPattern(script, "", nil, nil)
+		l.entries = append(l.entries, e)
+	}
+}
+
+// WithGroovySnippetMatch appends a groovy entry matching embedded Groovy
+// scripts that contain every snippet and match every pattern. Snippets are
+// compared token by token, so their spacing and quoting do not matter.
+// Patterns are matched against the script's canonical text: comments
+// removed, strings single-quoted, semicolons dropped and spaces only
+// between adjacent words, as in "def n=rows.size()".
+func WithGroovySnippetMatch(snippets []string, patterns []*regexp.Regexp, resp Response) InMemoryResponseLoaderOption {
+	return func(l *InMemoryResponseLoader) {
+		e := l.entry(MatchTypeGroovy, resp)
+		e.setGroovyPattern("", "", snippets, patterns)
+		l.entries = append(l.entries, e)
+	}
+}
+
+// WithBuiltinOverride marks every entry appended by opt as an override for a
+// built-in command (ping, login user, logout user), for example:
+//
+//	WithBuiltinOverride(WithPrefixMatch("login user", NewResponse(523).WithMessage("Locked").Build()))
+//
+// Overrides take precedence over mocka's hardcoded handling of those commands.
+func WithBuiltinOverride(opt InMemoryResponseLoaderOption) InMemoryResponseLoaderOption {
+	return func(l *InMemoryResponseLoader) {
+		n := len(l.entries)
+		opt(l)
+		for i := n; i < len(l.entries); i++ {
+			l.entries[i].Builtin = true
+		}
 	}
+}
+
+// WithArgs requires every entry appended by opt to match only queries whose
+// where-clause arguments and publish data context satisfy preds, keyed by
+// argument name, for example:
+//
+//	WithArgs(map[string]ArgPredicate{"wh_id": ArgIn("WMD1", "WMD2"), "ordnum": ArgGlob("SO-*")},
+//		WithPrefixMatch("list orders", resp))
+func WithArgs(preds map[string]ArgPredicate, opt InMemoryResponseLoaderOption) InMemoryResponseLoaderOption {
+	return func(l *InMemoryResponseLoader) {
+		n := len(l.entries)
+		opt(l)
+		for i := n; i < len(l.entries); i++ {
+			l.entries[i].Args = normalizeArgPredicates(preds)
+		}
+	}
+}
+
+// WithPriority sets the priority of every entry appended by opt. Entries
+// with a higher priority are tried first across all match types, for
+// example to make a specific prefix beat a generic publish_data entry:
+//
+//	WithPriority(10, WithPrefixMatch("list orders where ordtyp = 'R'", returns))
+//
+// A negative priority makes an entry a last resort.
+func WithPriority(priority int, opt InMemoryResponseLoaderOption) InMemoryResponseLoaderOption {
 	return func(l *InMemoryResponseLoader) {
-		l.entries = append(l.entries, Entry{
-			MatchType:  MatchTypePublishData,
-			Inner:      normalizeQuery(inner),
-			Context:    normalized,
-			StatusCode: resp.StatusCode,
-			Message:    resp.Message,
-			ResultSet:  resp.ResultSet,
-		})
+		n := len(l.entries)
+		opt(l)
+		for i := n; i < len(l.entries); i++ {
+			l.entries[i].Priority = priority
+		}
 	}
 }
 
+// entry returns newEntry(matchType, resp) set to the loader's current
+// normalization mode.
+func (l *InMemoryResponseLoader) entry(matchType MatchType, resp Response) Entry {
+	e := newEntry(matchType, resp)
+	e.Normalization = l.normalization
+	return e
+}
+
+// newEntry returns an Entry of the given match type carrying the status,
+// message, result set and session requirement from resp.
+func newEntry(matchType MatchType, resp Response) Entry {
+	return Entry{
+		MatchType:      matchType,
+		StatusCode:     resp.StatusCode,
+		Message:        resp.Message,
+		ResultSet:      resp.ResultSet,
+		RequireSession: resp.RequireSession,
+		lazy:           resp.lazy,
+	}
+}
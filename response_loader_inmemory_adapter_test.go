@@ -128,3 +128,55 @@ func TestInMemoryResponseLoader(t *testing.T) {
 		})
 	})
 }
+
+func TestInMemoryResponseLoader_BuiltinOverride(t *testing.T) {
+
+	Convey("WithBuiltinOverride marks only the wrapped option's entries as builtin", t, func() {
+		entries, _ := NewInMemoryResponseLoader(
+			WithExactMatch("list warehouses", NewResponse(StatusOK).Build()),
+			WithBuiltinOverride(WithPrefixMatch("login user", NewResponse(StatusOK).Build())),
+		).Load()
+
+		So(entries, ShouldHaveLength, 2)
+		So(entries[0].Builtin, ShouldBeFalse)
+		So(entries[1].Builtin, ShouldBeTrue)
+		So(entries[1].Prefix, ShouldEqual, "login user")
+	})
+}
+
+func TestInMemoryResponseLoader_Normalization(t *testing.T) {
+
+	Convey("Given entries registered under different normalization modes", t, func() {
+		entries, _ := NewInMemoryResponseLoader(
+			WithNormalization(NormalizationLiteral),
+			WithExactMatch("list items where descr = 'A=B'", NewResponse(StatusOK).Build()),
+			WithNormalizedMatch(NormalizationLossy, WithExactMatch("list items where descr = 'A=B'", NewResponse(StatusOK).Build())),
+			WithContextualPublishDataMatch("list items", map[string]string{"descr": "Mixed"}, NewResponse(StatusOK).Build()),
+		).Load()
+
+		So(entries, ShouldHaveLength, 3)
+		So(entries[0].Normalization, ShouldEqual, NormalizationLiteral)
+		So(entries[0].Query, ShouldEqual, "list items where descr = 'A=B'")
+		So(entries[1].Normalization, ShouldEqual, NormalizationLossy)
+		So(entries[1].Query, ShouldEqual, "list items where descr = 'a = b'")
+		So(entries[2].Context, ShouldResemble, map[string]string{"descr": "Mixed"})
+	})
+}
+
+func TestInMemoryResponseLoader_Priority(t *testing.T) {
+
+	Convey("Given entries appended with WithPriority", t, func() {
+		entries, _ := NewInMemoryResponseLoader(
+			WithPriority(10, func(l *InMemoryResponseLoader) {
+				WithExactMatch("list orders", NewResponse(StatusOK).Build())(l)
+				WithPrefixMatch("list", NewResponse(StatusOK).Build())(l)
+			}),
+			WithExactMatch("list areas", NewResponse(StatusOK).Build()),
+		).Load()
+
+		So(entries, ShouldHaveLength, 3)
+		So(entries[0].Priority, ShouldEqual, 10)
+		So(entries[1].Priority, ShouldEqual, 10)
+		So(entries[2].Priority, ShouldEqual, 0)
+	})
+}
@@ -3,8 +3,12 @@ package mocka
 import (
 	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
 
 	"github.com/goccy/go-yaml"
@@ -19,17 +23,45 @@ type matchSpec struct {
 	Inner     string            `yaml:"inner,omitempty"`
 	Context   map[string]string `yaml:"context,omitempty"`
 	Prefix    string            `yaml:"prefix,omitempty"`
+	SQL       string            `yaml:"sql,omitempty"`
+	Table     string            `yaml:"table,omitempty"`
+	Columns   []string          `yaml:"columns,omitempty"`
+	Args      map[string]string `yaml:"args,omitempty"`
+	Priority  int               `yaml:"priority,omitempty"`
+	Builtin   bool              `yaml:"builtin,omitempty"` // overrides ping, login user or logout user
+
+	Script      string   `yaml:"script,omitempty"`      // groovy: matched by fingerprint
+	Fingerprint string   `yaml:"fingerprint,omitempty"` // groovy: a GroovyFingerprint
+	Contains    []string `yaml:"contains,omitempty"`    // groovy: required snippets
+	Matches     []string `yaml:"matches,omitempty"`     // groovy: regexes over the canonical script
+
+	Normalization string `yaml:"normalization,omitempty"` // lossy or literal; defaults to the loader's mode
 }
 
 type responseSpec struct {
-	Status  int    `yaml:"status"`
-	Message string `yaml:"message,omitempty"`
-	Results string `yaml:"results,omitempty"` // path to XML file, relative to data folder
+	Status  int               `yaml:"status"`
+	Message string            `yaml:"message,omitempty"`
+	Results string            `yaml:"results,omitempty"`  // path to XML, CSV, JSON or YAML file, relative to data folder
+	Types   map[string]string `yaml:"types,omitempty"`    // column name → MOCA type code for CSV/JSON/YAML results
+	TypeRow bool              `yaml:"type_row,omitempty"` // CSV results: the row after the header holds type codes
+	Columns []columnSpec      `yaml:"columns,omitempty"`  // inline result set metadata
+	Rows    []any             `yaml:"rows,omitempty"`     // inline rows: lists in column order, or maps by column name
+	XML     string            `yaml:"xml,omitempty"`      // inline moca-results XML
+
+	Synthetic *SyntheticSpec `yaml:"synthetic,omitempty"` // generated result set
+}
+
+type columnSpec struct {
+	Name     string `yaml:"name"`
+	Type     string `yaml:"type,omitempty"` // MOCA type code; defaults to S
+	Length   int    `yaml:"length,omitempty"`
+	Nullable *bool  `yaml:"nullable,omitempty"` // defaults to true
 }
 
 type rawEntry struct {
 	Match    matchSpec    `yaml:"match"`
 	RespSpec responseSpec `yaml:"response"`
+	Auth     string       `yaml:"auth,omitempty"` // "required" or "none"; consulted in SessionModeEntry
 }
 
 type responseFile struct {
@@ -38,30 +70,59 @@ type responseFile struct {
 
 // --- FileResponseLoader ---
 
+// FileResponseLoaderOption configures a FileResponseLoader.
+type FileResponseLoaderOption func(*FileResponseLoader)
+
 // FileResponseLoader loads responses from YAML files on disk.
 type FileResponseLoader struct {
-	dataFolder string
+	dataFolder    string
+	validation    ValidationLevel
+	normalization NormalizationMode
 }
 
 var _ ResponseLoader = (*FileResponseLoader)(nil)
 
 // NewFileResponseLoader creates a FileResponseLoader that reads YAML response
 // files from the given directory.
-func NewFileResponseLoader(dataFolder string) *FileResponseLoader {
-	return &FileResponseLoader{dataFolder: dataFolder}
+func NewFileResponseLoader(dataFolder string, opts ...FileResponseLoaderOption) *FileResponseLoader {
+	l := &FileResponseLoader{dataFolder: dataFolder, validation: ValidationWarn}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// WithFileValidation sets how result sets that violate MOCA type semantics
+// are treated at load time. The default is ValidationWarn.
+func WithFileValidation(level ValidationLevel) FileResponseLoaderOption {
+	return func(l *FileResponseLoader) {
+		l.validation = level
+	}
+}
+
+// WithFileNormalization sets how the query patterns of entries without a
+// match.normalization of their own are normalized. The default is
+// NormalizationLossy.
+func WithFileNormalization(mode NormalizationMode) FileResponseLoaderOption {
+	return func(l *FileResponseLoader) {
+		l.normalization = mode
+	}
 }
 
 // Load implements ResponseLoader by reading responses.yml from the data folder.
 // A missing file is treated as an empty registry; malformed files return an error.
 func (l *FileResponseLoader) Load() ([]Entry, error) {
-	entries, err := loadResponseFile(filepath.Join(l.dataFolder, "responses.yml"), l.dataFolder)
+	entries, err := loadResponseFile(filepath.Join(l.dataFolder, "responses.yml"), l.dataFolder, l.normalization)
+	if err == nil {
+		err = validateEntries(entries, l.validation, slog.Default())
+	}
 	if err != nil {
 		return nil, fmt.Errorf("loading responses.yml: %w", err)
 	}
 	return entries, nil
 }
 
-func loadResponseFile(path, dataFolder string) ([]Entry, error) {
+func loadResponseFile(path, dataFolder string, normalization NormalizationMode) ([]Entry, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
@@ -73,18 +134,36 @@ func loadResponseFile(path, dataFolder string) ([]Entry, error) {
 	if err := yaml.Unmarshal(data, &f); err != nil {
 		return nil, fmt.Errorf("parsing %s: %w", path, err)
 	}
-	return buildEntries(f.Responses, dataFolder)
+	return buildEntries(f.Responses, dataFolder, normalization)
 }
 
-// buildEntries normalizes all query strings and loads any referenced XML result
-// files, returning a slice of ready-to-match Entry values.
-func buildEntries(raws []rawEntry, dataFolder string) ([]Entry, error) {
+// buildEntries normalizes all query strings, with normalization unless an
+// entry selects its own mode, and loads any referenced result files,
+// returning a slice of ready-to-match Entry values.
+func buildEntries(raws []rawEntry, dataFolder string, normalization NormalizationMode) ([]Entry, error) {
 	entries := make([]Entry, 0, len(raws))
-	for _, r := range raws {
+	for i, r := range raws {
 		e := Entry{
-			MatchType:  MatchType(r.Match.Type),
-			StatusCode: r.RespSpec.Status,
-			Message:    r.RespSpec.Message,
+			MatchType:     MatchType(r.Match.Type),
+			StatusCode:    r.RespSpec.Status,
+			Message:       r.RespSpec.Message,
+			Builtin:       r.Match.Builtin,
+			Priority:      r.Match.Priority,
+			Normalization: normalization,
+		}
+		if r.Match.Normalization != "" {
+			mode, err := ParseNormalizationMode(r.Match.Normalization)
+			if err != nil {
+				return nil, fmt.Errorf("entry %d: %w", i+1, err)
+			}
+			e.Normalization = mode
+		}
+		switch r.Auth {
+		case "", "none":
+		case "required":
+			e.RequireSession = true
+		default:
+			return nil, fmt.Errorf("entry %d: unknown auth value %q", i+1, r.Auth)
 		}
 		switch MatchType(r.Match.Type) {
 		case MatchTypeExact:
@@ -93,29 +172,144 @@ func buildEntries(raws []rawEntry, dataFolder string) ([]Entry, error) {
 				if err != nil {
 					return nil, fmt.Errorf("reading query_file %s: %w", r.Match.QueryFile, err)
 				}
-				e.Query = normalizeQuery(string(raw))
+				e.Query = normalizeQueryMode(string(raw), e.Normalization)
 			} else {
-				e.Query = normalizeQuery(r.Match.Query)
+				e.Query = normalizeQueryMode(r.Match.Query, e.Normalization)
 			}
 		case MatchTypePublishData:
-			e.Inner = normalizeQuery(r.Match.Inner)
-			// Normalize context values to lowercase for consistent comparison
-			// with values extracted from the normalized (lowercased) incoming query.
-			e.Context = make(map[string]string)
-			for k, v := range r.Match.Context {
-				e.Context[k] = strings.ToLower(v)
+			e.Inner = normalizeQueryMode(r.Match.Inner, e.Normalization)
+			// Normalize context values (lowercased unless literal) for consistent
+			// comparison with values extracted from the normalized incoming query.
+			e.Context = normalizeContext(maps.Clone(r.Match.Context), e.Normalization)
+			if e.Context == nil {
+				e.Context = make(map[string]string)
 			}
 		case MatchTypePrefix:
-			e.Prefix = normalizeQuery(r.Match.Prefix)
+			e.Prefix = normalizeQueryMode(r.Match.Prefix, e.Normalization)
+		case MatchTypeSQL:
+			if r.Match.SQL == "" && r.Match.Table == "" && len(r.Match.Columns) == 0 {
+				return nil, fmt.Errorf("entry %d: sql match requires sql, table or columns", i+1)
+			}
+			e.setSQLPattern(r.Match.SQL, r.Match.Table, r.Match.Columns)
+		case MatchTypeGroovy:
+			if r.Match.Script == "" && r.Match.Fingerprint == "" && len(r.Match.Contains) == 0 && len(r.Match.Matches) == 0 {
+				return nil, fmt.Errorf("entry %d: groovy match requires script, fingerprint, contains or matches", i+1)
+			}
+			patterns := make([]*regexp.Regexp, len(r.Match.Matches))
+			for j, expr := range r.Match.Matches {
+				re, err := regexp.Compile(expr)
+				if err != nil {
+					return nil, fmt.Errorf("entry %d: groovy matches: %w", i+1, err)
+				}
+				patterns[j] = re
+			}
+			e.setGroovyPattern(r.Match.Script, r.Match.Fingerprint, r.Match.Contains, patterns)
+		}
+		if len(r.Match.Args) > 0 {
+			preds := make(map[string]ArgPredicate, len(r.Match.Args))
+			for _, name := range slices.Sorted(maps.Keys(r.Match.Args)) {
+				p, err := ParseArgPredicate(r.Match.Args[name])
+				if err != nil {
+					return nil, fmt.Errorf("entry %d: args %s: %w", i+1, name, err)
+				}
+				preds[name] = p
+			}
+			e.Args = normalizeArgPredicates(preds)
 		}
-		if r.RespSpec.Results != "" {
-			xmlRaw, err := os.ReadFile(filepath.Join(dataFolder, r.RespSpec.Results))
+		sources := 0
+		for _, set := range []bool{r.RespSpec.Results != "", len(r.RespSpec.Columns) > 0, r.RespSpec.XML != "", r.RespSpec.Synthetic != nil} {
+			if set {
+				sources++
+			}
+		}
+		if sources > 1 {
+			return nil, fmt.Errorf("entry %d: only one of results, columns, xml or synthetic may be set", i+1)
+		}
+		switch {
+		case r.RespSpec.Results != "":
+			resultSet, err := loadResultsFile(filepath.Join(dataFolder, r.RespSpec.Results), r.RespSpec.Types, r.RespSpec.TypeRow)
 			if err != nil {
 				return nil, fmt.Errorf("reading results file %s: %w", r.RespSpec.Results, err)
 			}
-			e.ResultSet = strings.TrimSpace(string(xmlRaw))
+			e.ResultSet = resultSet
+		case len(r.RespSpec.Columns) > 0:
+			resultSet, err := inlineResultSet(r.RespSpec.Columns, r.RespSpec.Rows)
+			if err != nil {
+				return nil, fmt.Errorf("entry %d: inline result set: %w", i+1, err)
+			}
+			e.ResultSet = resultSet
+		case len(r.RespSpec.Rows) > 0:
+			return nil, fmt.Errorf("entry %d: rows require columns", i+1)
+		case r.RespSpec.XML != "":
+			e.ResultSet = strings.TrimSpace(r.RespSpec.XML)
+		case r.RespSpec.Synthetic != nil:
+			resultSet, lazy, err := r.RespSpec.Synthetic.resultSet()
+			if err != nil {
+				return nil, fmt.Errorf("entry %d: synthetic result set: %w", i+1, err)
+			}
+			e.ResultSet, e.lazy = resultSet, lazy
 		}
 		entries = append(entries, e)
 	}
 	return entries, nil
 }
+
+// inlineResultSet converts inline columns and rows from responses.yml to a
+// <moca-results> XML fragment.
+func inlineResultSet(columns []columnSpec, rows []any) (string, error) {
+	rs := NewResultSet()
+	types := make([]ColumnType, len(columns))
+	for i, c := range columns {
+		types[i] = TypeString
+		if c.Type != "" {
+			t, err := parseColumnType(c.Type)
+			if err != nil {
+				return "", fmt.Errorf("column %s: %w", c.Name, err)
+			}
+			types[i] = t
+		}
+		var opts []ColumnOption
+		if c.Length > 0 {
+			opts = append(opts, ColumnLength(c.Length))
+		}
+		if c.Nullable != nil && !*c.Nullable {
+			opts = append(opts, ColumnNotNull())
+		}
+		rs.Column(c.Name, types[i], opts...)
+	}
+	for n, row := range rows {
+		var values []any
+		switch row := row.(type) {
+		case []any:
+			values = row
+		case map[string]any:
+			values = make([]any, len(columns))
+			for i, c := range columns {
+				values[i] = row[c.Name]
+			}
+			for k := range row {
+				if !slices.ContainsFunc(columns, func(c columnSpec) bool { return c.Name == k }) {
+					return "", fmt.Errorf("row %d: unknown column %s", n+1, k)
+				}
+			}
+		default:
+			return "", fmt.Errorf("row %d: expected a list or a map, got %T", n+1, row)
+		}
+		for i, v := range values {
+			if s, ok := v.(string); ok && i < len(types) {
+				converted, err := convertCell(s, types[i])
+				if err != nil {
+					return "", fmt.Errorf("row %d, column %s: %w", n+1, columns[i].Name, err)
+				}
+				values[i] = converted
+			}
+		}
+		rs.Row(values...)
+	}
+	// Type semantics are checked by the loader at its own validation level.
+	results, err := rs.Validation(ValidationOff).Build()
+	if err != nil {
+		return "", err
+	}
+	return marshalResults(results)
+}
@@ -1,10 +1,12 @@
 package mocka
 
 import (
+	"encoding/xml"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/castingcode/mocaprotocol"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -212,3 +214,542 @@ responses:
 		})
 	})
 }
+
+func TestFileResponseLoader_Auth(t *testing.T) {
+
+	Convey("FileResponseLoader — auth", t, func() {
+
+		Convey("Given an entry marked auth: required", func() {
+			dir := t.TempDir()
+			writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: exact
+      query: "list secrets"
+    response:
+      status: 0
+    auth: required
+  - match:
+      type: exact
+      query: "list warehouses"
+    response:
+      status: 0
+`)
+			entries, err := loaderFor(dir).Load()
+
+			Convey("Then only the marked entry requires a session", func() {
+				So(err, ShouldBeNil)
+				So(entries[0].RequireSession, ShouldBeTrue)
+				So(entries[1].RequireSession, ShouldBeFalse)
+			})
+		})
+
+		Convey("Given an entry with an unknown auth value", func() {
+			dir := t.TempDir()
+			writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: exact
+      query: "list secrets"
+    response:
+      status: 0
+    auth: sometimes
+`)
+			_, err := loaderFor(dir).Load()
+
+			Convey("Then an error is returned naming the entry and the value", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "entry 1")
+				So(err.Error(), ShouldContainSubstring, "sometimes")
+			})
+		})
+	})
+}
+
+func TestFileResponseLoader_Builtin(t *testing.T) {
+
+	Convey("FileResponseLoader — builtin overrides", t, func() {
+		dir := t.TempDir()
+		writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: exact
+      query: "ping"
+      builtin: true
+    response:
+      status: 511
+      message: "Database Error"
+`)
+		entries, err := loaderFor(dir).Load()
+
+		Convey("Then the entry is marked as a builtin override", func() {
+			So(err, ShouldBeNil)
+			So(entries[0].Builtin, ShouldBeTrue)
+			So(entries[0].Query, ShouldEqual, "ping")
+		})
+	})
+}
+
+func TestFileResponseLoader_Normalization(t *testing.T) {
+
+	Convey("FileResponseLoader — normalization modes", t, func() {
+		dir := t.TempDir()
+		writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: exact
+      query: "LIST items where descr = 'A=B'"
+    response:
+      status: 0
+  - match:
+      type: publish_data
+      inner: "list items"
+      context:
+        descr: "Mixed"
+      normalization: literal
+    response:
+      status: 0
+`)
+
+		Convey("Entries are lossy by default and match.normalization overrides", func() {
+			entries, err := loaderFor(dir).Load()
+			So(err, ShouldBeNil)
+			So(entries[0].Query, ShouldEqual, "list items where descr = 'a = b'")
+			So(entries[1].Normalization, ShouldEqual, NormalizationLiteral)
+			So(entries[1].Context, ShouldResemble, map[string]string{"descr": "Mixed"})
+		})
+
+		Convey("WithFileNormalization sets the default mode", func() {
+			entries, err := NewFileResponseLoader(dir, WithFileNormalization(NormalizationLiteral)).Load()
+			So(err, ShouldBeNil)
+			So(entries[0].Normalization, ShouldEqual, NormalizationLiteral)
+			So(entries[0].Query, ShouldEqual, "list items where descr = 'A=B'")
+		})
+
+		Convey("An unknown mode is an error", func() {
+			writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: exact
+      query: "ping"
+      normalization: strict
+    response:
+      status: 0
+`)
+			_, err := loaderFor(dir).Load()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestFileResponseLoader_SQL(t *testing.T) {
+
+	Convey("FileResponseLoader — sql entries", t, func() {
+		dir := t.TempDir()
+
+		Convey("Patterns are stored in canonical form", func() {
+			writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: sql
+      sql: "SELECT a,b FROM t WHERE y=:y AND x=1"
+    response:
+      status: 0
+  - match:
+      type: sql
+      table: LOCMST
+      columns: [stoloc, "count(*)"]
+    response:
+      status: 0
+`)
+			entries, err := loaderFor(dir).Load()
+			So(err, ShouldBeNil)
+			So(entries[0].SQL, ShouldEqual, "select a , b from t where x = 1 and y = ?")
+			So(entries[1].Table, ShouldEqual, "locmst")
+			So(entries[1].Columns, ShouldResemble, []string{"stoloc", "count ( * )"})
+		})
+
+		Convey("An sql entry without patterns is an error", func() {
+			writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: sql
+    response:
+      status: 0
+`)
+			_, err := loaderFor(dir).Load()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestFileResponseLoader_Groovy(t *testing.T) {
+
+	Convey("FileResponseLoader — groovy entries", t, func() {
+		dir := t.TempDir()
+
+		Convey("A script is stored as its fingerprint and snippets canonically", func() {
+			writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: groovy
+      script: |
+        // comment
+        def rows = moca.executeCommand("list locations")
+    response:
+      status: 0
+  - match:
+      type: groovy
+      contains: ["moca.executeCommand( 'list areas' )"]
+      matches: ['size\(\)']
+    response:
+      status: 0
+`)
+			entries, err := loaderFor(dir).Load()
+			So(err, ShouldBeNil)
+			So(entries[0].Fingerprint, ShouldEqual, GroovyFingerprint(`def x = moca.executeCommand('list locations')`, NormalizationLossy))
+			So(entries[1].Snippets, ShouldResemble, []string{"moca.executecommand('list areas')"})
+			So(entries[1].Patterns, ShouldHaveLength, 1)
+		})
+
+		Convey("An invalid regex is an error", func() {
+			writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: groovy
+      matches: ['(']
+    response:
+      status: 0
+`)
+			_, err := loaderFor(dir).Load()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("A groovy entry without patterns is an error", func() {
+			writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: groovy
+    response:
+      status: 0
+`)
+			_, err := loaderFor(dir).Load()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestFileResponseLoader_Args(t *testing.T) {
+
+	Convey("FileResponseLoader — args predicates", t, func() {
+		dir := t.TempDir()
+
+		Convey("Predicates are parsed and keyed by lowercased name", func() {
+			writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: prefix
+      prefix: list orders
+      args:
+        WH_ID: in (WMD1, WMD2)
+        qty: "> 0"
+    response:
+      status: 0
+`)
+			entries, err := loaderFor(dir).Load()
+			So(err, ShouldBeNil)
+			So(entries[0].Args, ShouldHaveLength, 2)
+			So(entries[0].Args["wh_id"].String(), ShouldEqual, "in (WMD1, WMD2)")
+			So(entries[0].Args["qty"].String(), ShouldEqual, "gt 0")
+		})
+
+		Convey("A malformed predicate is an error naming the argument", func() {
+			writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: prefix
+      prefix: list orders
+      args:
+        qty: "> many"
+    response:
+      status: 0
+`)
+			_, err := loaderFor(dir).Load()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "args qty")
+		})
+	})
+}
+
+func TestFileResponseLoader_Priority(t *testing.T) {
+
+	Convey("FileResponseLoader — entry priority", t, func() {
+		dir := t.TempDir()
+		writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: publish_data
+      inner: list orders
+    response:
+      status: 0
+      message: generic
+  - match:
+      type: prefix
+      prefix: "publish data where ordtyp = 'R'"
+      priority: 10
+    response:
+      status: 0
+      message: returns
+`)
+
+		Convey("Priority is loaded and orders the lookup", func() {
+			entries, err := loaderFor(dir).Load()
+			So(err, ShouldBeNil)
+			So(entries[1].Priority, ShouldEqual, 10)
+
+			lookup, err := NewResponseLookup(loaderFor(dir))
+			So(err, ShouldBeNil)
+			So(lookup.GetResponse(normalizeQuery("publish data where ordtyp = 'R' | { list orders }")).Message, ShouldEqual, "returns")
+		})
+	})
+}
+
+func TestFileResponseLoader_InlineResults(t *testing.T) {
+
+	Convey("FileResponseLoader — inline result sets", t, func() {
+
+		Convey("Given an entry with inline columns and rows", func() {
+			dir := t.TempDir()
+			writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: exact
+      query: "list warehouses"
+    response:
+      status: 0
+      columns:
+        - name: wh_id
+          length: 10
+          nullable: false
+        - name: qty
+          type: I
+        - name: adddte
+          type: D
+      rows:
+        - [MHE, 10, "2024-01-02"]
+        - {wh_id: WMD, qty: null}
+`)
+			entries, err := loaderFor(dir).Load()
+
+			Convey("Then the rows are converted to moca-results XML", func() {
+				So(err, ShouldBeNil)
+				var results mocaprotocol.MocaResults
+				So(xml.Unmarshal([]byte(entries[0].ResultSet), &results), ShouldBeNil)
+				So(results.Metadata.Columns, ShouldHaveLength, 3)
+				So(results.Metadata.Columns[0].Type, ShouldEqual, "S")
+				So(results.Metadata.Columns[0].Length, ShouldEqual, "10")
+				So(results.Metadata.Columns[0].Nullable, ShouldEqual, "false")
+				So(results.Metadata.Columns[1].Type, ShouldEqual, "I")
+				So(results.Data.Rows, ShouldHaveLength, 2)
+				So(results.Data.Rows[0].Fields[1].Value, ShouldEqual, "10")
+				So(results.Data.Rows[0].Fields[2].Value, ShouldEqual, "20240102000000")
+				So(results.Data.Rows[1].Fields[0].Value, ShouldEqual, "WMD")
+				So(results.Data.Rows[1].Fields[1].Null, ShouldEqual, "true")
+				So(results.Data.Rows[1].Fields[2].Null, ShouldEqual, "true")
+			})
+		})
+
+		Convey("Given an entry with an inline XML block", func() {
+			dir := t.TempDir()
+			writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: exact
+      query: "list warehouses"
+    response:
+      status: 0
+      xml: |
+        <moca-results><metadata/><data/></moca-results>
+`)
+			entries, err := loaderFor(dir).Load()
+
+			Convey("Then the XML is stored trimmed", func() {
+				So(err, ShouldBeNil)
+				So(entries[0].ResultSet, ShouldEqual, "<moca-results><metadata/><data/></moca-results>")
+			})
+		})
+
+		Convey("Given an inline row with the wrong number of values", func() {
+			dir := t.TempDir()
+			writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: exact
+      query: "list warehouses"
+    response:
+      status: 0
+      columns:
+        - name: wh_id
+      rows:
+        - [MHE, extra]
+`)
+			_, err := loaderFor(dir).Load()
+
+			Convey("Then an error identifies the entry and row", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "entry 1")
+				So(err.Error(), ShouldContainSubstring, "row 1")
+			})
+		})
+
+		Convey("Given an inline row naming an unknown column", func() {
+			dir := t.TempDir()
+			writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: exact
+      query: "list warehouses"
+    response:
+      status: 0
+      columns:
+        - name: wh_id
+      rows:
+        - {wh_name: Main}
+`)
+			_, err := loaderFor(dir).Load()
+
+			Convey("Then an error names the column", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "wh_name")
+			})
+		})
+
+		Convey("Given an entry with both a results file and inline columns", func() {
+			dir := t.TempDir()
+			writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: exact
+      query: "list warehouses"
+    response:
+      status: 0
+      results: r.xml
+      columns:
+        - name: wh_id
+`)
+			_, err := loaderFor(dir).Load()
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "only one of results, columns, xml or synthetic")
+			})
+		})
+
+		Convey("Given rows without columns", func() {
+			dir := t.TempDir()
+			writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: exact
+      query: "list warehouses"
+    response:
+      status: 0
+      rows:
+        - [MHE]
+`)
+			_, err := loaderFor(dir).Load()
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "rows require columns")
+			})
+		})
+	})
+}
+
+func TestFileResponseLoader_Synthetic(t *testing.T) {
+
+	Convey("FileResponseLoader — synthetic result sets", t, func() {
+
+		Convey("Given an entry with a synthetic spec", func() {
+			dir := t.TempDir()
+			writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: exact
+      query: "list inventory"
+    response:
+      status: 0
+      synthetic:
+        seed: 1
+        rows: 25
+        columns:
+          - name: lodnum
+            generator: sequence
+            prefix: LOD
+          - name: wh_id
+            generator: enum
+            values: [MHE, WMD]
+`)
+			entries, err := loaderFor(dir).Load()
+
+			Convey("Then the rows are generated at load time", func() {
+				So(err, ShouldBeNil)
+				var results mocaprotocol.MocaResults
+				So(xml.Unmarshal([]byte(entries[0].ResultSet), &results), ShouldBeNil)
+				So(results.Data.Rows, ShouldHaveLength, 25)
+				So(results.Data.Rows[24].Fields[0].Value, ShouldEqual, "LOD25")
+			})
+		})
+
+		Convey("Given a lazy synthetic spec", func() {
+			dir := t.TempDir()
+			writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: exact
+      query: "list inventory"
+    response:
+      status: 0
+      synthetic:
+        rows: 3
+        lazy: true
+        columns:
+          - name: id
+            generator: sequence
+`)
+			entries, err := loaderFor(dir).Load()
+
+			Convey("Then generation is deferred to the first request", func() {
+				So(err, ShouldBeNil)
+				So(entries[0].ResultSet, ShouldBeEmpty)
+				out, err := entries[0].response().resultSet()
+				So(err, ShouldBeNil)
+				So(out, ShouldContainSubstring, "<moca-results>")
+			})
+		})
+
+		Convey("Given a synthetic spec with an unknown generator", func() {
+			dir := t.TempDir()
+			writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: exact
+      query: "list inventory"
+    response:
+      status: 0
+      synthetic:
+        rows: 3
+        columns:
+          - name: id
+            generator: bogus
+`)
+			_, err := loaderFor(dir).Load()
+
+			Convey("Then loading fails", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, `entry 1: synthetic result set: column id: unknown generator "bogus"`)
+			})
+		})
+	})
+}
@@ -0,0 +1,200 @@
+package mocka
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/castingcode/mocaprotocol"
+)
+
+// MocaDateFormat is the layout MOCA uses for date (D) column values.
+const MocaDateFormat = "20060102150405"
+
+// ColumnType is a MOCA result set column type code.
+type ColumnType string
+
+const (
+	TypeString  ColumnType = "S"
+	TypeInteger ColumnType = "I"
+	TypeFloat   ColumnType = "F"
+	TypeDate    ColumnType = "D"
+	TypeFlag    ColumnType = "R"
+	TypeBoolean ColumnType = "O"
+)
+
+// ColumnOption configures a column added with ResultSetBuilder.Column.
+type ColumnOption func(*mocaprotocol.Column)
+
+// ColumnLength sets the column's declared length.
+func ColumnLength(n int) ColumnOption {
+	return func(c *mocaprotocol.Column) {
+		c.Length = strconv.Itoa(n)
+	}
+}
+
+// ColumnNotNull marks the column as non-nullable; nil values in it are
+// reported by Build's validation.
+func ColumnNotNull() ColumnOption {
+	return func(c *mocaprotocol.Column) {
+		c.Nullable = "false"
+	}
+}
+
+// ResultSetBuilder constructs a typed MOCA result set. Use NewResultSet to
+// obtain a builder, declare every column, then add rows:
+//
+//	rs := NewResultSet().
+//		Column("wh_id", TypeString, ColumnLength(10), ColumnNotNull()).
+//		Column("adddte", TypeDate).
+//		Row("MHE", time.Now()).
+//		Row("WMD", nil)
+//
+// Row values may be nil, strings, bools, integers, floats, time.Time,
+// fmt.Stringers or pointers to any of these. The first error encountered is
+// reported by Build, which also validates the result set with
+// ValidateResults at the builder's ValidationLevel (strict by default).
+type ResultSetBuilder struct {
+	columns    []mocaprotocol.Column
+	rows       []mocaprotocol.Row
+	validation ValidationLevel
+	err        error
+}
+
+// NewResultSet returns an empty ResultSetBuilder.
+func NewResultSet() *ResultSetBuilder {
+	return &ResultSetBuilder{validation: ValidationStrict}
+}
+
+// Validation sets how Build treats values that violate MOCA type semantics.
+func (b *ResultSetBuilder) Validation(level ValidationLevel) *ResultSetBuilder {
+	b.validation = level
+	return b
+}
+
+// Column appends a nullable column of the given type with length 0, then
+// applies opts. Columns must be declared before any rows are added.
+func (b *ResultSetBuilder) Column(name string, typ ColumnType, opts ...ColumnOption) *ResultSetBuilder {
+	if b.err == nil && len(b.rows) > 0 {
+		b.err = fmt.Errorf("column %s declared after rows were added", name)
+	}
+	c := mocaprotocol.Column{Name: name, Type: string(typ), Nullable: "true", Length: "0"}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	b.columns = append(b.columns, c)
+	return b
+}
+
+// Row appends a row. It must supply exactly one value per declared column.
+func (b *ResultSetBuilder) Row(values ...any) *ResultSetBuilder {
+	if b.err != nil {
+		return b
+	}
+	row := len(b.rows) + 1
+	if len(values) != len(b.columns) {
+		b.err = fmt.Errorf("row %d: got %d values for %d columns", row, len(values), len(b.columns))
+		return b
+	}
+	fields := make([]mocaprotocol.Field, len(values))
+	for i, v := range values {
+		col := b.columns[i]
+		value, null, err := formatValue(v)
+		if err != nil {
+			b.err = fmt.Errorf("row %d, column %s: %w", row, col.Name, err)
+			return b
+		}
+		if null {
+			fields[i] = mocaprotocol.Field{Null: "true"}
+			continue
+		}
+		fields[i] = mocaprotocol.Field{Value: value}
+	}
+	b.rows = append(b.rows, mocaprotocol.Row{Fields: fields})
+	return b
+}
+
+// Build returns the result set, or the first error recorded while building.
+func (b *ResultSetBuilder) Build() (mocaprotocol.MocaResults, error) {
+	if b.err != nil {
+		return mocaprotocol.MocaResults{}, b.err
+	}
+	results := mocaprotocol.MocaResults{
+		Metadata: mocaprotocol.Metadata{Columns: b.columns},
+		Data:     mocaprotocol.Data{Rows: b.rows},
+	}
+	if b.validation == ValidationOff {
+		return results, nil
+	}
+	if err := ValidateResults(results); err != nil {
+		if b.validation == ValidationStrict {
+			return mocaprotocol.MocaResults{}, err
+		}
+		var verr *ResultSetValidationError
+		if !errors.As(err, &verr) {
+			slog.Default().Warn("invalid result set", "error", err)
+			return results, nil
+		}
+		for _, issue := range verr.Issues {
+			slog.Default().Warn("invalid result set", "issue", issue.String())
+		}
+	}
+	return results, nil
+}
+
+// formatValue converts a Go value to its MOCA field text. null reports a nil
+// value (including nil pointers).
+func formatValue(v any) (value string, null bool, err error) {
+	if v == nil {
+		return "", true, nil
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return "", true, nil
+	}
+	switch t := v.(type) {
+	case time.Time:
+		return t.Format(MocaDateFormat), false, nil
+	case *time.Time: // ahead of fmt.Stringer, which *time.Time implements
+		return t.Format(MocaDateFormat), false, nil
+	case fmt.Stringer:
+		return t.String(), false, nil
+	}
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return "", true, nil
+		}
+		return formatValue(rv.Elem().Interface())
+	case reflect.String:
+		return rv.String(), false, nil
+	case reflect.Bool:
+		if rv.Bool() {
+			return "1", false, nil
+		}
+		return "0", false, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), false, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), false, nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'f', -1, rv.Type().Bits()), false, nil
+	}
+	return "", false, fmt.Errorf("unsupported value type %T", v)
+}
+
+// marshalResults encodes results as a <moca-results> XML fragment, the form
+// stored in Response.ResultSet.
+func marshalResults(results mocaprotocol.MocaResults) (string, error) {
+	var buf strings.Builder
+	enc := xml.NewEncoder(&buf)
+	if err := enc.EncodeElement(results, xml.StartElement{Name: xml.Name{Local: "moca-results"}}); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
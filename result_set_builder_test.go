@@ -0,0 +1,137 @@
+package mocka
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/castingcode/mocaprotocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testStatus int
+
+func (s testStatus) String() string { return [...]string{"OPEN", "CLOSED"}[s] }
+
+func TestResultSetBuilder(t *testing.T) {
+
+	Convey("ResultSetBuilder", t, func() {
+
+		Convey("builds typed columns", func() {
+			results, err := NewResultSet().
+				Column("wh_id", TypeString, ColumnLength(10), ColumnNotNull()).
+				Column("qty", TypeInteger).
+				Build()
+
+			So(err, ShouldBeNil)
+			So(results.Metadata.Columns, ShouldHaveLength, 2)
+			So(results.Metadata.Columns[0].Name, ShouldEqual, "wh_id")
+			So(results.Metadata.Columns[0].Type, ShouldEqual, "S")
+			So(results.Metadata.Columns[0].Length, ShouldEqual, "10")
+			So(results.Metadata.Columns[0].Nullable, ShouldEqual, "false")
+			So(results.Metadata.Columns[1].Type, ShouldEqual, "I")
+			So(results.Metadata.Columns[1].Length, ShouldEqual, "0")
+			So(results.Metadata.Columns[1].Nullable, ShouldEqual, "true")
+		})
+
+		Convey("formats Go values the way MOCA does", func() {
+			qty := 7
+			var missing *int
+			results, err := NewResultSet().
+				Column("s", TypeString).
+				Column("i", TypeInteger).
+				Column("f", TypeFloat).
+				Column("d", TypeDate).
+				Column("r", TypeFlag).
+				Column("o", TypeBoolean).
+				Column("p", TypeInteger).
+				Column("n", TypeInteger).
+				Column("st", TypeString).
+				Row("MHE", int64(-3), 1.5, time.Date(2024, 3, 9, 14, 5, 7, 0, time.UTC), true, false, &qty, missing, testStatus(1)).
+				Row(nil, uint8(4), float32(2.25), "20240101000000", false, true, nil, nil, nil).
+				Build()
+
+			So(err, ShouldBeNil)
+			So(results.Data.Rows, ShouldHaveLength, 2)
+			first := results.Data.Rows[0].Fields
+			So(first[0].Value, ShouldEqual, "MHE")
+			So(first[1].Value, ShouldEqual, "-3")
+			So(first[2].Value, ShouldEqual, "1.5")
+			So(first[3].Value, ShouldEqual, "20240309140507")
+			So(first[4].Value, ShouldEqual, "1")
+			So(first[5].Value, ShouldEqual, "0")
+			So(first[6].Value, ShouldEqual, "7")
+			So(first[7].Null, ShouldEqual, "true")
+			So(first[8].Value, ShouldEqual, "CLOSED")
+			second := results.Data.Rows[1].Fields
+			So(second[0].Null, ShouldEqual, "true")
+			So(second[1].Value, ShouldEqual, "4")
+			So(second[2].Value, ShouldEqual, "2.25")
+			So(second[3].Value, ShouldEqual, "20240101000000")
+		})
+
+		Convey("formats pointers to time.Time as MOCA dates", func() {
+			when := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+			var none *time.Time
+			results, err := NewResultSet().
+				Column("d", TypeDate).
+				Row(&when).
+				Row(none).
+				Build()
+
+			So(err, ShouldBeNil)
+			So(results.Data.Rows[0].Fields[0].Value, ShouldEqual, "20240102030405")
+			So(results.Data.Rows[1].Fields[0].Null, ShouldEqual, "true")
+		})
+
+		Convey("rejects a row with the wrong number of values", func() {
+			_, err := NewResultSet().Column("a", TypeString).Column("b", TypeString).Row("x").Build()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "row 1: got 1 values for 2 columns")
+		})
+
+		Convey("rejects nil in a non-nullable column", func() {
+			_, err := NewResultSet().Column("wh_id", TypeString, ColumnNotNull()).Row("MHE").Row(nil).Build()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "row 2, column wh_id")
+		})
+
+		Convey("rejects unsupported value types", func() {
+			_, err := NewResultSet().Column("a", TypeString).Row([]int{1}).Build()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "unsupported value type []int")
+		})
+
+		Convey("rejects columns declared after rows", func() {
+			_, err := NewResultSet().Column("a", TypeString).Row("x").Column("b", TypeString).Build()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "column b declared after rows")
+		})
+	})
+}
+
+func TestResponseBuilder_WithResultSetBuilder(t *testing.T) {
+
+	Convey("WithResultSetBuilder", t, func() {
+
+		Convey("stores the result set as moca-results XML", func() {
+			rb, err := NewResponse(StatusOK).WithResultSetBuilder(
+				NewResultSet().Column("wh_id", TypeString).Row("MHE"),
+			)
+			So(err, ShouldBeNil)
+
+			resp := rb.Build()
+			So(resp.ResultSet, ShouldStartWith, "<moca-results>")
+			var results mocaprotocol.MocaResults
+			So(xml.Unmarshal([]byte(resp.ResultSet), &results), ShouldBeNil)
+			So(results.Data.Rows[0].Fields[0].Value, ShouldEqual, "MHE")
+		})
+
+		Convey("returns the builder's error", func() {
+			_, err := NewResponse(StatusOK).WithResultSetBuilder(
+				NewResultSet().Column("wh_id", TypeString).Row(),
+			)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,169 @@
+package mocka
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// structColumn maps a struct field to a result set column.
+type structColumn struct {
+	index []int
+	name  string
+	typ   ColumnType
+	opts  []ColumnOption
+}
+
+// NewResultSetFromStructs returns a ResultSetBuilder with one column per
+// exported field of the slice's element type and one row per element.
+// rows must be a slice of structs or of pointers to structs; a nil pointer
+// element is an error. Fields are configured with a moca tag:
+//
+//	type Warehouse struct {
+//		ID      string    `moca:"wh_id,type=S,len=10,notnull"`
+//		Added   time.Time `moca:"adddte"`
+//		Ignored string    `moca:"-"`
+//	}
+//
+// Without a tag the column name is the snake_case field name, and the type
+// is inferred from the Go type: strings are S, integers I, floats F, bools R
+// and time.Time D. Pointer fields take the type of their element, and nil
+// pointers produce null fields.
+func NewResultSetFromStructs(rows any) *ResultSetBuilder {
+	b := NewResultSet()
+	rv := reflect.ValueOf(rows)
+	if rv.Kind() != reflect.Slice {
+		b.err = fmt.Errorf("expected a slice of structs, got %T", rows)
+		return b
+	}
+	elem := rv.Type().Elem()
+	ptr := elem.Kind() == reflect.Pointer
+	if ptr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct {
+		b.err = fmt.Errorf("expected a slice of structs, got %T", rows)
+		return b
+	}
+	columns, err := structColumns(elem)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	for _, c := range columns {
+		b.Column(c.name, c.typ, c.opts...)
+	}
+	for i := range rv.Len() {
+		item := rv.Index(i)
+		if ptr {
+			if item.IsNil() {
+				b.err = fmt.Errorf("row %d: nil element", i+1)
+				return b
+			}
+			item = item.Elem()
+		}
+		values := make([]any, len(columns))
+		for j, c := range columns {
+			values[j] = item.FieldByIndex(c.index).Interface()
+		}
+		b.Row(values...)
+	}
+	return b
+}
+
+// structColumns derives the column list for struct type t from its fields
+// and moca tags.
+func structColumns(t reflect.Type) ([]structColumn, error) {
+	var columns []structColumn
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("moca")
+		if tag == "-" {
+			continue
+		}
+		c := structColumn{index: f.Index, name: snakeCase(f.Name)}
+		parts := strings.Split(tag, ",")
+		if parts[0] != "" {
+			c.name = parts[0]
+		}
+		for _, opt := range parts[1:] {
+			key, val, _ := strings.Cut(strings.TrimSpace(opt), "=")
+			switch key {
+			case "type":
+				typ, err := parseColumnType(val)
+				if err != nil {
+					return nil, fmt.Errorf("field %s: %w", f.Name, err)
+				}
+				c.typ = typ
+			case "len":
+				n, err := strconv.Atoi(val)
+				if err != nil {
+					return nil, fmt.Errorf("field %s: invalid len %q", f.Name, val)
+				}
+				c.opts = append(c.opts, ColumnLength(n))
+			case "notnull":
+				c.opts = append(c.opts, ColumnNotNull())
+			default:
+				return nil, fmt.Errorf("field %s: unknown moca tag option %q", f.Name, key)
+			}
+		}
+		if c.typ == "" {
+			typ, ok := inferColumnType(f.Type)
+			if !ok {
+				return nil, fmt.Errorf("field %s: cannot infer MOCA type for %s", f.Name, f.Type)
+			}
+			c.typ = typ
+		}
+		columns = append(columns, c)
+	}
+	return columns, nil
+}
+
+// inferColumnType returns the MOCA column type for Go type t.
+func inferColumnType(t reflect.Type) (ColumnType, bool) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return TypeDate, true
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return TypeString, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return TypeInteger, true
+	case reflect.Float32, reflect.Float64:
+		return TypeFloat, true
+	case reflect.Bool:
+		return TypeFlag, true
+	}
+	return "", false
+}
+
+// snakeCase converts a Go field name such as WhID or PrtClientID to
+// wh_id or prt_client_id.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var out strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			prevLower := i > 0 && !unicode.IsUpper(runes[i-1])
+			nextLower := i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if i > 0 && (prevLower || nextLower) {
+				out.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
@@ -0,0 +1,120 @@
+package mocka
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testWarehouse struct {
+	ID       string    `moca:"wh_id,type=S,len=10,notnull"`
+	Added    time.Time `moca:"adddte"`
+	Qty      *int
+	Weight   float64
+	Active   bool
+	Shipped  *time.Time `moca:"shpdte"`
+	Internal string     `moca:"-"`
+	hidden   string
+}
+
+func TestNewResultSetFromStructs(t *testing.T) {
+
+	Convey("NewResultSetFromStructs", t, func() {
+
+		Convey("derives columns from tags and Go types", func() {
+			results, err := NewResultSetFromStructs([]testWarehouse{}).Build()
+			So(err, ShouldBeNil)
+
+			cols := results.Metadata.Columns
+			So(cols, ShouldHaveLength, 6)
+			So(cols[0].Name, ShouldEqual, "wh_id")
+			So(cols[0].Type, ShouldEqual, "S")
+			So(cols[0].Length, ShouldEqual, "10")
+			So(cols[0].Nullable, ShouldEqual, "false")
+			So(cols[1].Name, ShouldEqual, "adddte")
+			So(cols[1].Type, ShouldEqual, "D")
+			So(cols[2].Name, ShouldEqual, "qty")
+			So(cols[2].Type, ShouldEqual, "I")
+			So(cols[3].Type, ShouldEqual, "F")
+			So(cols[4].Name, ShouldEqual, "active")
+			So(cols[4].Type, ShouldEqual, "R")
+			So(cols[5].Name, ShouldEqual, "shpdte")
+			So(cols[5].Type, ShouldEqual, "D")
+			So(cols[5].Nullable, ShouldEqual, "true")
+		})
+
+		Convey("produces one row per element, with nil pointers as nulls", func() {
+			qty := 3
+			shipped := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)
+			results, err := NewResultSetFromStructs([]*testWarehouse{
+				{ID: "MHE", Added: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Qty: &qty, Weight: 2.5, Active: true, Shipped: &shipped, hidden: "x"},
+				{ID: "WMD"},
+			}).Build()
+			So(err, ShouldBeNil)
+
+			So(results.Data.Rows, ShouldHaveLength, 2)
+			first := results.Data.Rows[0].Fields
+			So(first[0].Value, ShouldEqual, "MHE")
+			So(first[1].Value, ShouldEqual, "20240102030405")
+			So(first[2].Value, ShouldEqual, "3")
+			So(first[3].Value, ShouldEqual, "2.5")
+			So(first[4].Value, ShouldEqual, "1")
+			So(first[5].Value, ShouldEqual, "20240203040506")
+			So(results.Data.Rows[1].Fields[2].Null, ShouldEqual, "true")
+			So(results.Data.Rows[1].Fields[5].Null, ShouldEqual, "true")
+		})
+
+		Convey("rejects values that are not slices of structs", func() {
+			_, err := NewResultSetFromStructs([]string{"a"}).Build()
+			So(err, ShouldNotBeNil)
+			_, err = NewResultSetFromStructs(testWarehouse{}).Build()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("rejects unknown tag options", func() {
+			type bad struct {
+				A string `moca:"a,size=3"`
+			}
+			_, err := NewResultSetFromStructs([]bad{}).Build()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "size")
+		})
+
+		Convey("rejects unknown type codes", func() {
+			type bad struct {
+				A string `moca:"a,type=Q"`
+			}
+			_, err := NewResultSetFromStructs([]bad{}).Build()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, `field A: unknown column type "Q"`)
+		})
+
+		Convey("rejects fields whose type cannot be inferred", func() {
+			type bad struct {
+				Tags []string
+			}
+			_, err := NewResultSetFromStructs([]bad{}).Build()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "Tags")
+		})
+
+		Convey("rejects nil elements", func() {
+			_, err := NewResultSetFromStructs([]*testWarehouse{nil}).Build()
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("snakeCase", t, func() {
+		So(snakeCase("WhID"), ShouldEqual, "wh_id")
+		So(snakeCase("PrtClientID"), ShouldEqual, "prt_client_id")
+		So(snakeCase("URLPath"), ShouldEqual, "url_path")
+		So(snakeCase("Qty"), ShouldEqual, "qty")
+	})
+
+	Convey("ResponseBuilder.WithStructs stores the structs as result XML", t, func() {
+		rb, err := NewResponse(StatusOK).WithStructs([]testWarehouse{{ID: "MHE"}})
+		So(err, ShouldBeNil)
+		So(rb.Build().ResultSet, ShouldContainSubstring, "MHE")
+	})
+}
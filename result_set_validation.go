@@ -0,0 +1,178 @@
+package mocka
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/castingcode/mocaprotocol"
+)
+
+// ValidationLevel controls what happens when a result set violates MOCA type
+// semantics.
+type ValidationLevel string
+
+const (
+	// ValidationOff skips validation.
+	ValidationOff ValidationLevel = "off"
+	// ValidationWarn logs each problem and carries on. This is the default
+	// for loaders.
+	ValidationWarn ValidationLevel = "warn"
+	// ValidationStrict fails with an error listing every problem. This is
+	// the default for ResultSetBuilder.
+	ValidationStrict ValidationLevel = "strict"
+)
+
+// ParseValidationLevel converts s to a ValidationLevel, returning an error
+// for unrecognized values.
+func ParseValidationLevel(s string) (ValidationLevel, error) {
+	switch l := ValidationLevel(s); l {
+	case ValidationOff, ValidationWarn, ValidationStrict:
+		return l, nil
+	}
+	return "", fmt.Errorf("unknown validation level %q", s)
+}
+
+// ResultSetIssue is a single problem found by ValidateResults. Row is
+// 1-based; it is 0 for problems with the metadata itself.
+type ResultSetIssue struct {
+	Row     int
+	Column  string
+	Message string
+}
+
+func (i ResultSetIssue) String() string {
+	if i.Row == 0 {
+		return fmt.Sprintf("column %s: %s", i.Column, i.Message)
+	}
+	return fmt.Sprintf("row %d, column %s: %s", i.Row, i.Column, i.Message)
+}
+
+// ResultSetValidationError reports every issue found in a result set.
+type ResultSetValidationError struct {
+	Issues []ResultSetIssue
+}
+
+func (e *ResultSetValidationError) Error() string {
+	msgs := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		msgs[i] = issue.String()
+	}
+	return "invalid result set: " + strings.Join(msgs, "; ")
+}
+
+// ValidateResults checks results against MOCA type semantics: every column
+// must have a unique name and a known type code, I values must be integers,
+// F values numbers, D values dates in MocaDateFormat, R and O values 0 or 1,
+// and S values no longer than a non-zero declared length. Non-nullable
+// columns must not hold nulls and every row must have one field per column.
+// It returns a *ResultSetValidationError, or nil if results are valid.
+func ValidateResults(results mocaprotocol.MocaResults) error {
+	var issues []ResultSetIssue
+	columns := results.Metadata.Columns
+	seen := make(map[string]bool)
+	for i, c := range columns {
+		name := c.Name
+		if name == "" {
+			name = "#" + strconv.Itoa(i+1)
+			issues = append(issues, ResultSetIssue{Column: name, Message: "empty name"})
+		} else if seen[strings.ToLower(name)] {
+			issues = append(issues, ResultSetIssue{Column: name, Message: "duplicate name"})
+		}
+		seen[strings.ToLower(c.Name)] = true
+		if _, err := parseColumnType(c.Type); err != nil {
+			issues = append(issues, ResultSetIssue{Column: name, Message: err.Error()})
+		}
+	}
+	for n, row := range results.Data.Rows {
+		if len(row.Fields) != len(columns) {
+			issues = append(issues, ResultSetIssue{
+				Row:     n + 1,
+				Column:  "*",
+				Message: fmt.Sprintf("got %d fields for %d columns", len(row.Fields), len(columns)),
+			})
+			continue
+		}
+		for i, f := range row.Fields {
+			if msg := validateField(columns[i], f); msg != "" {
+				issues = append(issues, ResultSetIssue{Row: n + 1, Column: columns[i].Name, Message: msg})
+			}
+		}
+	}
+	if len(issues) > 0 {
+		return &ResultSetValidationError{Issues: issues}
+	}
+	return nil
+}
+
+// validateField returns a description of what is wrong with f as a value of
+// column c, or "" if it is valid.
+func validateField(c mocaprotocol.Column, f mocaprotocol.Field) string {
+	if f.Null == "true" {
+		if c.Nullable == "false" {
+			return "null in non-nullable column"
+		}
+		return ""
+	}
+	switch ColumnType(c.Type) {
+	case TypeInteger:
+		if _, err := strconv.ParseInt(f.Value, 10, 64); err != nil {
+			return fmt.Sprintf("%q is not an integer", f.Value)
+		}
+	case TypeFloat:
+		if _, err := strconv.ParseFloat(f.Value, 64); err != nil {
+			return fmt.Sprintf("%q is not a number", f.Value)
+		}
+	case TypeDate:
+		if _, err := time.Parse(MocaDateFormat, f.Value); err != nil || len(f.Value) != len(MocaDateFormat) {
+			return fmt.Sprintf("%q is not a MOCA date (YYYYMMDDHHmmss)", f.Value)
+		}
+	case TypeFlag, TypeBoolean:
+		if f.Value != "0" && f.Value != "1" {
+			return fmt.Sprintf("%q is not 0 or 1", f.Value)
+		}
+	case TypeString:
+		if n, err := strconv.Atoi(c.Length); err == nil && n > 0 && utf8.RuneCountInString(f.Value) > n {
+			return fmt.Sprintf("%q exceeds length %d", f.Value, n)
+		}
+	}
+	return ""
+}
+
+// validateEntries validates the result set of every entry at level. Result
+// sets that are not well-formed XML are left for NewResponseLookup to report.
+func validateEntries(entries []Entry, level ValidationLevel, logger *slog.Logger) error {
+	if level == ValidationOff {
+		return nil
+	}
+	for i, e := range entries {
+		if e.ResultSet == "" {
+			continue
+		}
+		var results mocaprotocol.MocaResults
+		if err := xml.Unmarshal([]byte(e.ResultSet), &results); err != nil {
+			continue
+		}
+		err := ValidateResults(results)
+		if err == nil {
+			continue
+		}
+		if level == ValidationStrict {
+			return fmt.Errorf("entry %d: %w", i+1, err)
+		}
+		var verr *ResultSetValidationError
+		if !errors.As(err, &verr) {
+			logger.Warn("invalid result set", "entry", i+1, "error", err)
+			continue
+		}
+		for _, issue := range verr.Issues {
+			logger.Warn("invalid result set", "entry", i+1, "issue", issue.String())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package mocka
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/castingcode/mocaprotocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestValidateResults(t *testing.T) {
+
+	column := func(name string, typ ColumnType) mocaprotocol.Column {
+		return mocaprotocol.Column{Name: name, Type: string(typ), Nullable: "true", Length: "0"}
+	}
+	results := func(columns []mocaprotocol.Column, rows ...[]mocaprotocol.Field) mocaprotocol.MocaResults {
+		r := mocaprotocol.MocaResults{Metadata: mocaprotocol.Metadata{Columns: columns}}
+		for _, fields := range rows {
+			r.Data.Rows = append(r.Data.Rows, mocaprotocol.Row{Fields: fields})
+		}
+		return r
+	}
+	issues := func(err error) []string {
+		var verr *ResultSetValidationError
+		if !errors.As(err, &verr) {
+			return nil
+		}
+		var out []string
+		for _, i := range verr.Issues {
+			out = append(out, i.String())
+		}
+		return out
+	}
+
+	Convey("ValidateResults", t, func() {
+
+		Convey("accepts values that match their column types", func() {
+			err := ValidateResults(results(
+				[]mocaprotocol.Column{column("s", TypeString), column("i", TypeInteger), column("f", TypeFloat), column("d", TypeDate), column("r", TypeFlag), column("o", TypeBoolean)},
+				[]mocaprotocol.Field{{Value: "x"}, {Value: "-12"}, {Value: "1.5"}, {Value: "20240309140507"}, {Value: "1"}, {Value: "0"}},
+				[]mocaprotocol.Field{{Null: "true"}, {Null: "true"}, {Null: "true"}, {Null: "true"}, {Null: "true"}, {Null: "true"}},
+			))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("reports each bad value with its row and column", func() {
+			err := ValidateResults(results(
+				[]mocaprotocol.Column{column("i", TypeInteger), column("f", TypeFloat), column("d", TypeDate), column("r", TypeFlag)},
+				[]mocaprotocol.Field{{Value: "1.5"}, {Value: "abc"}, {Value: "2024-03-09"}, {Value: "Y"}},
+			))
+			So(issues(err), ShouldResemble, []string{
+				`row 1, column i: "1.5" is not an integer`,
+				`row 1, column f: "abc" is not a number`,
+				`row 1, column d: "2024-03-09" is not a MOCA date (YYYYMMDDHHmmss)`,
+				`row 1, column r: "Y" is not 0 or 1`,
+			})
+		})
+
+		Convey("reports nulls in non-nullable columns", func() {
+			c := column("wh_id", TypeString)
+			c.Nullable = "false"
+			err := ValidateResults(results([]mocaprotocol.Column{c}, []mocaprotocol.Field{{Value: "MHE"}}, []mocaprotocol.Field{{Null: "true"}}))
+			So(issues(err), ShouldResemble, []string{"row 2, column wh_id: null in non-nullable column"})
+		})
+
+		Convey("reports strings longer than their declared length", func() {
+			c := column("wh_id", TypeString)
+			c.Length = "3"
+			err := ValidateResults(results([]mocaprotocol.Column{c}, []mocaprotocol.Field{{Value: "MHE"}}, []mocaprotocol.Field{{Value: "MHEX"}}))
+			So(issues(err), ShouldResemble, []string{`row 2, column wh_id: "MHEX" exceeds length 3`})
+		})
+
+		Convey("reports rows whose width differs from the metadata", func() {
+			err := ValidateResults(results([]mocaprotocol.Column{column("a", TypeString)}, []mocaprotocol.Field{{Value: "x"}, {Value: "y"}}))
+			So(issues(err), ShouldResemble, []string{"row 1, column *: got 2 fields for 1 columns"})
+		})
+
+		Convey("reports empty and duplicate names and unknown types in the metadata", func() {
+			err := ValidateResults(results([]mocaprotocol.Column{column("a", TypeString), column("", TypeString), column("A", TypeInteger), column("q", "Q")}))
+			So(issues(err), ShouldResemble, []string{
+				"column #2: empty name",
+				"column A: duplicate name",
+				`column q: unknown column type "Q"`,
+			})
+		})
+	})
+
+	Convey("ParseValidationLevel", t, func() {
+		for _, s := range []string{"off", "warn", "strict"} {
+			level, err := ParseValidationLevel(s)
+			So(err, ShouldBeNil)
+			So(string(level), ShouldEqual, s)
+		}
+		_, err := ParseValidationLevel("loud")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestResultSetValidation_Levels(t *testing.T) {
+
+	badXML := `<moca-results><metadata><column name="qty" type="I" length="0" nullable="true"/></metadata><data><row><field>abc</field></row></data></moca-results>`
+
+	Convey("ResultSetBuilder", t, func() {
+
+		Convey("is strict by default", func() {
+			_, err := NewResultSet().Column("qty", TypeInteger).Row("abc").Build()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, `row 1, column qty: "abc" is not an integer`)
+		})
+
+		Convey("builds invalid values when warning", func() {
+			results, err := NewResultSet().Column("qty", TypeInteger).Row("abc").Validation(ValidationWarn).Build()
+			So(err, ShouldBeNil)
+			So(results.Data.Rows[0].Fields[0].Value, ShouldEqual, "abc")
+		})
+	})
+
+	Convey("InMemoryResponseLoader", t, func() {
+
+		Convey("loads invalid result sets by default", func() {
+			entries, err := NewInMemoryResponseLoader(
+				WithExactMatch("q", NewResponse(StatusOK).WithResultSet(badXML).Build()),
+			).Load()
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 1)
+		})
+
+		Convey("rejects invalid result sets when strict", func() {
+			_, err := NewInMemoryResponseLoader(
+				WithValidation(ValidationStrict),
+				WithExactMatch("q", NewResponse(StatusOK).WithResultSet(badXML).Build()),
+			).Load()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "entry 1: invalid result set: row 1, column qty")
+		})
+	})
+
+	Convey("FileResponseLoader", t, func() {
+		dir := t.TempDir()
+		writeTestFile(t, dir, "bad.xml", badXML)
+		writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: exact
+      query: "q"
+    response:
+      status: 0
+      results: bad.xml
+`)
+
+		Convey("loads invalid result sets by default", func() {
+			_, err := NewFileResponseLoader(dir).Load()
+			So(err, ShouldBeNil)
+		})
+
+		Convey("rejects invalid result sets when strict", func() {
+			_, err := NewFileResponseLoader(dir, WithFileValidation(ValidationStrict)).Load()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "row 1, column qty")
+		})
+	})
+}
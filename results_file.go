@@ -0,0 +1,226 @@
+package mocka
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
+
+// dateLayouts are the layouts accepted for date (D) values in CSV, JSON and
+// YAML results files. Values are rewritten in MocaDateFormat.
+var dateLayouts = []string{
+	MocaDateFormat,
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// parseColumnType converts a MOCA type code such as "S" or "i" to a
+// ColumnType.
+func parseColumnType(s string) (ColumnType, error) {
+	switch t := ColumnType(strings.ToUpper(strings.TrimSpace(s))); t {
+	case TypeString, TypeInteger, TypeFloat, TypeDate, TypeFlag, TypeBoolean:
+		return t, nil
+	}
+	return "", fmt.Errorf("unknown column type %q", s)
+}
+
+// loadResultsFile reads a results file and returns it as a <moca-results>
+// XML fragment. The format is chosen by extension: .csv, .json, .yml and
+// .yaml are converted; anything else is treated as moca-results XML and
+// returned as-is. types maps column names to MOCA type codes, overriding any
+// inferred type. For CSV, typeRow reports that the row after the header
+// holds type codes.
+func loadResultsFile(path string, types map[string]string, typeRow bool) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var rs *ResultSetBuilder
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		rs, err = resultsFromCSV(string(data), types, typeRow)
+	case ".json", ".yml", ".yaml":
+		// JSON is a subset of YAML, so both go through the YAML decoder,
+		// which preserves key order.
+		rs, err = resultsFromRecords(data, types)
+	default:
+		return strings.TrimSpace(string(data)), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	// Type semantics are checked by the loader at its own validation level.
+	results, err := rs.Validation(ValidationOff).Build()
+	if err != nil {
+		return "", err
+	}
+	return marshalResults(results)
+}
+
+// resultsFromCSV converts CSV with a header row to a result set. Columns
+// default to type S; empty cells are null.
+func resultsFromCSV(data string, types map[string]string, typeRow bool) (*ResultSetBuilder, error) {
+	records, err := csv.NewReader(strings.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("missing header row")
+	}
+	header, records := records[0], records[1:]
+	colTypes := make([]ColumnType, len(header))
+	for i := range colTypes {
+		colTypes[i] = TypeString
+	}
+	if typeRow {
+		if len(records) == 0 {
+			return nil, fmt.Errorf("missing type row")
+		}
+		for i, code := range records[0] {
+			t, err := parseColumnType(code)
+			if err != nil {
+				return nil, fmt.Errorf("type row, column %s: %w", header[i], err)
+			}
+			colTypes[i] = t
+		}
+		records = records[1:]
+	}
+	if err := applyTypeMap(header, colTypes, types); err != nil {
+		return nil, err
+	}
+	rs := NewResultSet()
+	for i, name := range header {
+		rs.Column(name, colTypes[i])
+	}
+	for n, record := range records {
+		values := make([]any, len(record))
+		for i, cell := range record {
+			if cell == "" {
+				continue
+			}
+			v, err := convertCell(cell, colTypes[i])
+			if err != nil {
+				return nil, fmt.Errorf("row %d, column %s: %w", n+1, header[i], err)
+			}
+			values[i] = v
+		}
+		rs.Row(values...)
+	}
+	return rs, nil
+}
+
+// resultsFromRecords converts a JSON or YAML list of objects to a result
+// set. Columns are the union of keys in order of first appearance; types are
+// inferred from the values (integers I, other numbers F, bools R, everything
+// else S) unless set in types. Missing keys and nulls are null.
+func resultsFromRecords(data []byte, types map[string]string) (*ResultSetBuilder, error) {
+	var records []yaml.MapSlice
+	if err := yaml.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	var header []string
+	inferred := make(map[string]ColumnType)
+	for _, record := range records {
+		for _, item := range record {
+			name := fmt.Sprint(item.Key)
+			if !slices.Contains(header, name) {
+				header = append(header, name)
+			}
+			inferred[name] = widenType(inferred[name], item.Value)
+		}
+	}
+	colTypes := make([]ColumnType, len(header))
+	for i, name := range header {
+		colTypes[i] = inferred[name]
+		if colTypes[i] == "" {
+			colTypes[i] = TypeString
+		}
+	}
+	if err := applyTypeMap(header, colTypes, types); err != nil {
+		return nil, err
+	}
+	rs := NewResultSet()
+	for i, name := range header {
+		rs.Column(name, colTypes[i])
+	}
+	for n, record := range records {
+		values := make([]any, len(header))
+		for _, item := range record {
+			i := slices.Index(header, fmt.Sprint(item.Key))
+			v := item.Value
+			if s, ok := v.(string); ok {
+				converted, err := convertCell(s, colTypes[i])
+				if err != nil {
+					return nil, fmt.Errorf("row %d, column %s: %w", n+1, header[i], err)
+				}
+				v = converted
+			}
+			values[i] = v
+		}
+		rs.Row(values...)
+	}
+	return rs, nil
+}
+
+// applyTypeMap overrides colTypes with the entries of types, returning an
+// error for unknown type codes or columns not in header.
+func applyTypeMap(header []string, colTypes []ColumnType, types map[string]string) error {
+	for name, code := range types {
+		i := slices.Index(header, name)
+		if i < 0 {
+			return fmt.Errorf("types: no column named %s", name)
+		}
+		t, err := parseColumnType(code)
+		if err != nil {
+			return fmt.Errorf("types: column %s: %w", name, err)
+		}
+		colTypes[i] = t
+	}
+	return nil
+}
+
+// widenType returns the type inferred for a column that has so far been
+// inferred as current and now holds v.
+func widenType(current ColumnType, v any) ColumnType {
+	var t ColumnType
+	switch v.(type) {
+	case nil:
+		return current
+	case bool:
+		t = TypeFlag
+	case int, int64, uint64:
+		t = TypeInteger
+	case float64:
+		t = TypeFloat
+	default:
+		t = TypeString
+	}
+	switch {
+	case current == "" || current == t:
+		return t
+	case current == TypeInteger && t == TypeFloat, current == TypeFloat && t == TypeInteger:
+		return TypeFloat
+	}
+	return TypeString
+}
+
+// convertCell rewrites textual date values in MocaDateFormat; other values
+// are returned unchanged.
+func convertCell(cell string, typ ColumnType) (any, error) {
+	if typ != TypeDate {
+		return cell, nil
+	}
+	t, err := parseDate(cell)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
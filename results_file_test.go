@@ -0,0 +1,180 @@
+package mocka
+
+import (
+	"encoding/xml"
+	"path/filepath"
+	"testing"
+
+	"github.com/castingcode/mocaprotocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// loadTestResults writes content to name in a temp dir, loads it with
+// loadResultsFile and decodes the resulting XML.
+func loadTestResults(t *testing.T, name, content string, types map[string]string, typeRow bool) (mocaprotocol.MocaResults, error) {
+	t.Helper()
+	dir := t.TempDir()
+	writeTestFile(t, dir, name, content)
+	var results mocaprotocol.MocaResults
+	resultSet, err := loadResultsFile(filepath.Join(dir, name), types, typeRow)
+	if err != nil {
+		return results, err
+	}
+	if err := xml.Unmarshal([]byte(resultSet), &results); err != nil {
+		t.Fatalf("decoding %s: %v", resultSet, err)
+	}
+	return results, nil
+}
+
+func TestLoadResultsFile_CSV(t *testing.T) {
+
+	Convey("loadResultsFile — CSV", t, func() {
+
+		Convey("Given a CSV with only a header row of names", func() {
+			results, err := loadTestResults(t, "r.csv", "wh_id,qty\nMHE,10\nWMD,\n", nil, false)
+
+			Convey("Then every column is a string and empty cells are null", func() {
+				So(err, ShouldBeNil)
+				So(results.Metadata.Columns, ShouldHaveLength, 2)
+				So(results.Metadata.Columns[1].Name, ShouldEqual, "qty")
+				So(results.Metadata.Columns[1].Type, ShouldEqual, "S")
+				So(results.Data.Rows, ShouldHaveLength, 2)
+				So(results.Data.Rows[0].Fields[1].Value, ShouldEqual, "10")
+				So(results.Data.Rows[1].Fields[1].Null, ShouldEqual, "true")
+			})
+		})
+
+		Convey("Given a CSV with a type row", func() {
+			results, err := loadTestResults(t, "r.csv", "wh_id,qty,adddte\nS,I,D\nMHE,10,2024-03-09 14:05:07\n", nil, true)
+
+			Convey("Then the types are applied and dates are rewritten in MOCA format", func() {
+				So(err, ShouldBeNil)
+				So(results.Metadata.Columns[1].Type, ShouldEqual, "I")
+				So(results.Metadata.Columns[2].Type, ShouldEqual, "D")
+				So(results.Data.Rows, ShouldHaveLength, 1)
+				So(results.Data.Rows[0].Fields[2].Value, ShouldEqual, "20240309140507")
+			})
+		})
+
+		Convey("Given a CSV with a column type map", func() {
+			results, err := loadTestResults(t, "r.csv", "wh_id,qty\nMHE,10\n", map[string]string{"qty": "i"}, false)
+
+			Convey("Then the mapped column takes the given type", func() {
+				So(err, ShouldBeNil)
+				So(results.Metadata.Columns[1].Type, ShouldEqual, "I")
+			})
+		})
+
+		Convey("Given a type map naming an unknown column", func() {
+			_, err := loadTestResults(t, "r.csv", "wh_id\nMHE\n", map[string]string{"qty": "I"}, false)
+
+			Convey("Then an error names the column", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "qty")
+			})
+		})
+
+		Convey("Given a type row with an unknown type code", func() {
+			_, err := loadTestResults(t, "r.csv", "wh_id\nX\nMHE\n", nil, true)
+
+			Convey("Then an error is returned", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, `"X"`)
+			})
+		})
+
+		Convey("Given an unparseable date", func() {
+			_, err := loadTestResults(t, "r.csv", "adddte\nD\nyesterday\n", nil, true)
+
+			Convey("Then an error names the row and column", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "row 1, column adddte")
+			})
+		})
+	})
+}
+
+func TestLoadResultsFile_Records(t *testing.T) {
+
+	Convey("loadResultsFile — JSON and YAML", t, func() {
+
+		Convey("Given a JSON array of objects", func() {
+			results, err := loadTestResults(t, "r.json", `[
+				{"wh_id": "MHE", "qty": 10, "weight": 1, "active": true},
+				{"wh_id": "WMD", "qty": null, "weight": 2.5, "extra": "x"}
+			]`, nil, false)
+
+			Convey("Then columns keep their order of first appearance", func() {
+				So(err, ShouldBeNil)
+				names := []string{}
+				for _, c := range results.Metadata.Columns {
+					names = append(names, c.Name)
+				}
+				So(names, ShouldResemble, []string{"wh_id", "qty", "weight", "active", "extra"})
+			})
+
+			Convey("Then types are inferred from the values", func() {
+				cols := results.Metadata.Columns
+				So(cols[0].Type, ShouldEqual, "S")
+				So(cols[1].Type, ShouldEqual, "I")
+				So(cols[2].Type, ShouldEqual, "F")
+				So(cols[3].Type, ShouldEqual, "R")
+			})
+
+			Convey("Then nulls and missing keys are null fields", func() {
+				So(results.Data.Rows[0].Fields[4].Null, ShouldEqual, "true")
+				So(results.Data.Rows[1].Fields[1].Null, ShouldEqual, "true")
+				So(results.Data.Rows[1].Fields[2].Value, ShouldEqual, "2.5")
+				So(results.Data.Rows[0].Fields[3].Value, ShouldEqual, "1")
+			})
+		})
+
+		Convey("Given YAML rows with a date column typed by the type map", func() {
+			results, err := loadTestResults(t, "r.yml", `
+- wh_id: MHE
+  adddte: "2024-01-02"
+`, map[string]string{"adddte": "D"}, false)
+
+			Convey("Then the date is rewritten in MOCA format", func() {
+				So(err, ShouldBeNil)
+				So(results.Data.Rows[0].Fields[1].Value, ShouldEqual, "20240102000000")
+			})
+		})
+	})
+
+	Convey("loadResultsFile — XML files are returned trimmed and unchanged", t, func() {
+		dir := t.TempDir()
+		writeTestFile(t, dir, "r.xml", "\n<moca-results/>\n")
+		resultSet, err := loadResultsFile(filepath.Join(dir, "r.xml"), nil, false)
+		So(err, ShouldBeNil)
+		So(resultSet, ShouldEqual, "<moca-results/>")
+	})
+}
+
+func TestFileResponseLoader_ResultsFormats(t *testing.T) {
+
+	Convey("Given a responses.yml referencing a CSV results file with a type map", t, func() {
+		dir := t.TempDir()
+		writeTestFile(t, dir, "warehouses.csv", "wh_id,qty\nMHE,10\n")
+		writeTestFile(t, dir, "responses.yml", `
+responses:
+  - match:
+      type: exact
+      query: "list warehouses"
+    response:
+      status: 0
+      results: warehouses.csv
+      types:
+        qty: I
+`)
+		entries, err := loaderFor(dir).Load()
+
+		Convey("Then the entry holds the converted moca-results XML", func() {
+			So(err, ShouldBeNil)
+			var results mocaprotocol.MocaResults
+			So(xml.Unmarshal([]byte(entries[0].ResultSet), &results), ShouldBeNil)
+			So(results.Metadata.Columns[1].Type, ShouldEqual, "I")
+			So(results.Data.Rows[0].Fields[0].Value, ShouldEqual, "MHE")
+		})
+	})
+}
@@ -2,11 +2,65 @@ package mocka
 
 import (
 	"encoding/xml"
+	"fmt"
+	"math/rand"
 
 	"github.com/castingcode/mocaprotocol"
 	"github.com/google/uuid"
 )
 
+// SessionMode controls when MocaRequestHandler requires a valid SESSION_KEY
+// in the request environment.
+type SessionMode string
+
+const (
+	// SessionModeStrict requires a valid session for every non-builtin
+	// command. This is the default.
+	SessionModeStrict SessionMode = "strict"
+	// SessionModeDisabled skips session checks entirely.
+	SessionModeDisabled SessionMode = "disabled"
+	// SessionModeAutoCreate registers a session for any unknown
+	// SESSION_KEY instead of rejecting it. Requests with no key are still
+	// rejected.
+	SessionModeAutoCreate SessionMode = "auto"
+	// SessionModeEntry requires a valid session only for entries marked
+	// with RequireSession (YAML auth: required).
+	SessionModeEntry SessionMode = "entry"
+)
+
+// ParseSessionMode converts s to a SessionMode, returning an error for
+// unrecognized values.
+func ParseSessionMode(s string) (SessionMode, error) {
+	switch m := SessionMode(s); m {
+	case SessionModeStrict, SessionModeDisabled, SessionModeAutoCreate, SessionModeEntry:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown session mode %q", s)
+}
+
+// SessionKeyGenerator returns a new session key each time it is called.
+// It is invoked once per successful login.
+type SessionKeyGenerator func() string
+
+// SequentialSessionKeys returns a generator producing prefix1, prefix2, ...
+// in order. Useful for golden-file tests that assert on session_key.
+func SequentialSessionKeys(prefix string) SessionKeyGenerator {
+	n := 0
+	return func() string {
+		n++
+		return fmt.Sprintf("%s%d", prefix, n)
+	}
+}
+
+// SeededSessionKeys returns a generator producing UUIDs from a random source
+// seeded with seed, so the same seed always yields the same key sequence.
+func SeededSessionKeys(seed int64) SessionKeyGenerator {
+	r := rand.New(rand.NewSource(seed))
+	return func() string {
+		return uuid.Must(uuid.NewRandomFromReader(r)).String()
+	}
+}
+
 // SessionStore holds in-memory session key → user ID mappings.
 // There is no TTL, no eviction, and no concurrency protection — this is
 // intentional for a single-use test server.
@@ -43,34 +97,59 @@ func (s *SessionStore) Len() int {
 // environment. Returns (key, nil) on success or ("", errorResponseBody) on
 // failure.
 func (s *SessionStore) GetSessionKey(request mocaprotocol.MocaRequest) (string, []byte) {
+	if key, ok := s.validKey(request); ok {
+		return key, nil
+	}
+	return "", marshalMocaResponse(generateInvalidSessionResponse())
+}
+
+// validKey returns the first SESSION_KEY in the request environment that
+// names a known session.
+func (s *SessionStore) validKey(request mocaprotocol.MocaRequest) (string, bool) {
 	for _, v := range request.Environment.Vars {
 		if v.Name == "SESSION_KEY" {
 			if _, ok := s.sessions[v.Value]; ok {
-				return v.Value, nil
+				return v.Value, true
 			}
 		}
 	}
-	return "", generateErrorResponse(StatusInvalidSessionKey, "Invalid session key")
+	return "", false
 }
 
-func generatePingResponse() []byte {
-	body, err := xml.Marshal(mocaprotocol.MocaResponse{Status: 0})
-	if err != nil {
-		return nil
+// sessionKeyVar returns the first SESSION_KEY value in the request
+// environment, without validating it.
+func sessionKeyVar(request mocaprotocol.MocaRequest) (string, bool) {
+	for _, v := range request.Environment.Vars {
+		if v.Name == "SESSION_KEY" {
+			return v.Value, true
+		}
 	}
-	return append(XMLDeclaration, body...)
+	return "", false
 }
 
-func generateErrorResponse(status int, message string) []byte {
-	body, err := xml.Marshal(mocaprotocol.MocaResponse{Status: status, Message: message})
+// marshalMocaResponse returns response as a MOCA XML document, or nil if it
+// cannot be marshalled.
+func marshalMocaResponse(response mocaprotocol.MocaResponse) []byte {
+	body, err := xml.Marshal(response)
 	if err != nil {
 		return nil
 	}
 	return append(XMLDeclaration, body...)
 }
 
-func generateLoginResponse(userID string) ([]byte, string) {
-	sessionKey := uuid.NewString()
+func generatePingResponse() mocaprotocol.MocaResponse {
+	return mocaprotocol.MocaResponse{Status: 0}
+}
+
+func generateErrorResponse(status int, message string) mocaprotocol.MocaResponse {
+	return mocaprotocol.MocaResponse{Status: status, Message: message}
+}
+
+func generateInvalidSessionResponse() mocaprotocol.MocaResponse {
+	return generateErrorResponse(StatusInvalidSessionKey, "Invalid session key")
+}
+
+func generateLoginResponse(userID, sessionKey string, profile LoginProfile) mocaprotocol.MocaResponse {
 	response := mocaprotocol.MocaResponse{
 		MocaResults: mocaprotocol.MocaResults{
 			Metadata: mocaprotocol.Metadata{
@@ -95,9 +174,9 @@ func generateLoginResponse(userID string) ([]byte, string) {
 				Rows: []mocaprotocol.Row{
 					{Fields: []mocaprotocol.Field{
 						{Value: userID},
-						{Value: "US_ENGLISH"},
-						{Value: "WM,lm,SEAMLES,SEAMLES,3pl"},
-						{Value: "10"},
+						{Value: profile.LocaleID},
+						{Value: profile.AddonID},
+						{Value: profile.CustLvl},
 						{Value: sessionKey},
 						{Null: "true"},
 						{Null: "true"},
@@ -105,7 +184,7 @@ func generateLoginResponse(userID string) ([]byte, string) {
 						{Value: "0"},
 						{Value: "0"},
 						{Value: "0"},
-						{Value: "DEVELOPMENT"},
+						{Value: profile.SrvTyp},
 						{Value: "1"},
 						{Value: "0"},
 					}},
@@ -113,11 +192,17 @@ func generateLoginResponse(userID string) ([]byte, string) {
 			},
 		},
 	}
-	body, err := xml.Marshal(response)
-	if err != nil {
-		return nil, sessionKey
+	for _, c := range profile.Columns {
+		typ := c.Type
+		if typ == "" {
+			typ = mocaprotocol.MocaString
+		}
+		response.MocaResults.Metadata.Columns = append(response.MocaResults.Metadata.Columns,
+			mocaprotocol.Column{Name: c.Name, Type: typ, Nullable: "true", Length: "0"})
+		response.MocaResults.Data.Rows[0].Fields = append(response.MocaResults.Data.Rows[0].Fields,
+			mocaprotocol.Field{Value: c.Value})
 	}
-	return append(XMLDeclaration, body...), sessionKey
+	return response
 }
 
 func generateNoContentResponse() []byte {
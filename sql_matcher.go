@@ -0,0 +1,349 @@
+package mocka
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// sqlStatement is the canonical form of an embedded "[...]" SQL statement
+// used by sql entries. Tokens are lowercased outside string literals and
+// quoted identifiers, bind variables (:name, @name, ?) become "?", "<>"
+// becomes "!=", and the top-level AND predicates of the WHERE clause are
+// sorted, so statements that differ only in spacing, keyword case or
+// predicate order have equal tokens.
+type sqlStatement struct {
+	tokens  []string
+	tables  []string   // tables named in FROM and JOIN clauses
+	columns [][]string // names each selected column answers to
+}
+
+// sqlClauseKeywords end a FROM or WHERE clause.
+var sqlClauseKeywords = map[string]bool{
+	"where": true, "group": true, "order": true, "having": true, "union": true,
+	"intersect": true, "minus": true, "except": true, "limit": true, "fetch": true,
+	"offset": true, "for": true, "connect": true, "start": true, "on": true,
+	"using": true, "join": true, "inner": true, "left": true, "right": true,
+	"full": true, "cross": true, "outer": true,
+}
+
+// parseSQL canonicalizes the SQL text of an embedded statement.
+func parseSQL(text string) sqlStatement {
+	tokens := sortPredicates(tokenizeSQL(text))
+	return sqlStatement{tokens: tokens, tables: sqlTables(tokens), columns: sqlColumns(tokens)}
+}
+
+// canonicalSQL returns the canonical text of an embedded SQL statement, with
+// or without its brackets.
+func canonicalSQL(sql string) string {
+	return renderSQL(parseSQL(strings.TrimSuffix(strings.TrimPrefix(sql, "["), "]")).tokens)
+}
+
+// tokenizeSQL splits SQL text into canonical tokens.
+func tokenizeSQL(text string) []string {
+	var tokens []string
+	for i := 0; i < len(text); {
+		c := text[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '\'' || c == '"':
+			i++
+			for i < len(text) {
+				if text[i] == c {
+					if i+1 < len(text) && text[i+1] == c {
+						i += 2 // doubled quote escapes itself
+						continue
+					}
+					break
+				}
+				i++
+			}
+			i = min(i+1, len(text))
+			tokens = append(tokens, text[start:i])
+			continue
+		case isSQLDigit(c) || c == '.' && i+1 < len(text) && isSQLDigit(text[i+1]):
+			for i < len(text) && (isSQLDigit(text[i]) || text[i] == '.') {
+				i++
+			}
+		case isSQLWordByte(c):
+			for i < len(text) && (isSQLWordByte(text[i]) || isSQLDigit(text[i])) {
+				i++
+			}
+			tokens = append(tokens, strings.ToLower(text[start:i]))
+			continue
+		case (c == ':' || c == '@') && i+1 < len(text) && (isSQLWordByte(text[i+1]) || isSQLDigit(text[i+1])):
+			i++
+			for i < len(text) && (isSQLWordByte(text[i]) || isSQLDigit(text[i])) {
+				i++
+			}
+			tokens = append(tokens, "?")
+			continue
+		default:
+			i++
+			if i < len(text) {
+				switch op := text[start : i+1]; op {
+				case "<>", "!=", "<=", ">=", "||":
+					i++
+					if op == "<>" {
+						op = "!="
+					}
+					tokens = append(tokens, op)
+					continue
+				}
+			}
+		}
+		tokens = append(tokens, text[start:i])
+	}
+	return tokens
+}
+
+func isSQLDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+func isSQLWordByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' || c == '$' || c == '#'
+}
+
+// isSQLValue reports whether tok is a literal or bind variable, which a "?"
+// in an entry's statement stands for.
+func isSQLValue(tok string) bool {
+	return tok == "?" || tok[0] == '\'' || isSQLDigit(tok[0]) || tok[0] == '.' && len(tok) > 1
+}
+
+// renderSQL joins canonical tokens into text, without spaces around dots.
+func renderSQL(tokens []string) string {
+	var b strings.Builder
+	for i, tok := range tokens {
+		if i > 0 && tok != "." && tokens[i-1] != "." {
+			b.WriteByte(' ')
+		}
+		b.WriteString(tok)
+	}
+	return b.String()
+}
+
+// sqlNesting returns how tok changes the nesting depth of a statement:
+// parentheses and CASE ... END nest, so the ANDs and ORs inside them are
+// not top-level.
+func sqlNesting(tok string) int {
+	switch tok {
+	case "(", "case":
+		return 1
+	case ")", "end":
+		return -1
+	}
+	return 0
+}
+
+// sortPredicates sorts the top-level AND predicates of the outermost WHERE
+// clause. Clauses with a top-level OR are left alone.
+func sortPredicates(tokens []string) []string {
+	where, end := -1, len(tokens)
+	depth := 0
+	for i, tok := range tokens {
+		depth += sqlNesting(tok)
+		if depth != 0 {
+			continue
+		}
+		if where < 0 && tok == "where" {
+			where = i
+		} else if where >= 0 && (sqlClauseKeywords[tok] || tok == ";") {
+			end = i
+			break
+		}
+	}
+	if where < 0 {
+		return tokens
+	}
+	var preds [][]string
+	start, between := where+1, false
+	depth = 0
+	for i := start; i < end; i++ {
+		depth += sqlNesting(tokens[i])
+		if depth != 0 {
+			continue
+		}
+		switch tokens[i] {
+		case "or":
+			return tokens
+		case "between":
+			between = true
+		case "and":
+			if between {
+				between = false
+				continue
+			}
+			preds = append(preds, tokens[start:i])
+			start = i + 1
+		}
+	}
+	preds = append(preds, tokens[start:end])
+	key := func(pred []string) string {
+		masked := slices.Clone(pred)
+		for i, tok := range masked {
+			if isSQLValue(tok) {
+				masked[i] = "?"
+			}
+		}
+		return renderSQL(masked)
+	}
+	slices.SortStableFunc(preds, func(a, b []string) int { return cmp.Compare(key(a), key(b)) })
+	sorted := slices.Clone(tokens[:where+1])
+	for i, pred := range preds {
+		if i > 0 {
+			sorted = append(sorted, "and")
+		}
+		sorted = append(sorted, pred...)
+	}
+	return append(sorted, tokens[end:]...)
+}
+
+// sqlTables returns the tables named at the top level of FROM and JOIN
+// clauses, with any schema prefix.
+func sqlTables(tokens []string) []string {
+	var tables []string
+	inFrom, expectName, depth := false, false, 0
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if tok == "(" && depth == 0 && inFrom {
+			expectName = false // derived table; its alias is not a table name
+		}
+		switch tok {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+		if depth != 0 || tok == ")" {
+			continue
+		}
+		switch {
+		case tok == "from" || tok == "join":
+			inFrom, expectName = true, true
+		case sqlClauseKeywords[tok]:
+			inFrom = false
+		case inFrom && tok == ",":
+			expectName = true
+		case inFrom && expectName && isSQLWordByte(tok[0]):
+			name := tok
+			for i+2 < len(tokens) && tokens[i+1] == "." {
+				name += "." + tokens[i+2]
+				i += 2
+			}
+			tables = append(tables, name)
+			expectName = false
+		}
+	}
+	return tables
+}
+
+// sqlColumns returns, for each column of the top-level select list, the
+// names it answers to: its full text and its alias or final identifier.
+func sqlColumns(tokens []string) [][]string {
+	if len(tokens) == 0 || tokens[0] != "select" {
+		return nil
+	}
+	i := 1
+	for i < len(tokens) && (tokens[i] == "distinct" || tokens[i] == "all") {
+		i++
+	}
+	var columns [][]string
+	start, depth := i, 0
+	add := func(col []string) {
+		if len(col) == 0 {
+			return
+		}
+		names := []string{renderSQL(col)}
+		if last := col[len(col)-1]; isSQLWordByte(last[0]) && last != names[0] {
+			names = append(names, last)
+		}
+		columns = append(columns, names)
+	}
+	for ; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "(":
+			depth++
+		case ")":
+			depth--
+		}
+		if depth != 0 {
+			continue
+		}
+		if tokens[i] == "," {
+			add(tokens[start:i])
+			start = i + 1
+		} else if tokens[i] == "from" {
+			break
+		}
+	}
+	add(tokens[start:i])
+	return columns
+}
+
+// parseSQLQuery returns the canonical statement of query if it is a single
+// embedded SQL statement, optionally wrapped in a block.
+func parseSQLQuery(query string) (sqlStatement, bool) {
+	node, err := parseQuery(query)
+	if err != nil {
+		return sqlStatement{}, false
+	}
+	for {
+		switch n := node.(type) {
+		case *blockNode:
+			node = n.body
+			continue
+		case *sqlNode:
+			return parseSQL(n.text), true
+		}
+		return sqlStatement{}, false
+	}
+}
+
+// sqlMatches reports whether stmt satisfies every constraint set on the sql
+// entry e: its statement, where a "?" matches any literal or bind variable,
+// its table, and its selected columns.
+func sqlMatches(e Entry, stmt sqlStatement) bool {
+	if e.SQL != "" && !sqlTokensMatch(tokenizeSQL(e.SQL), stmt.tokens) {
+		return false
+	}
+	if e.Table != "" && !slices.Contains(stmt.tables, e.Table) {
+		return false
+	}
+	for _, col := range e.Columns {
+		if !slices.ContainsFunc(stmt.columns, func(names []string) bool { return slices.Contains(names, col) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// sqlTokensMatch compares an entry's canonical tokens with a query's.
+func sqlTokensMatch(pattern, tokens []string) bool {
+	if len(pattern) != len(tokens) {
+		return false
+	}
+	for i, tok := range pattern {
+		if tok != tokens[i] && (tok != "?" || !isSQLValue(tokens[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// setSQLPattern stores the patterns of the sql entry e in canonical form,
+// normalized in e's mode. sql may omit its brackets.
+func (e *Entry) setSQLPattern(sql, table string, columns []string) {
+	if sql = strings.TrimSpace(sql); sql != "" {
+		if !strings.HasPrefix(sql, "[") {
+			sql = "[" + sql + "]"
+		}
+		e.SQL = canonicalSQL(normalizeQueryMode(sql, e.Normalization))
+	}
+	e.Table = strings.ToLower(strings.TrimSpace(table))
+	e.Columns = nil
+	for _, col := range columns {
+		e.Columns = append(e.Columns, canonicalSQL(normalizeQueryMode("["+col+"]", e.Normalization)))
+	}
+}
@@ -0,0 +1,119 @@
+package mocka
+
+import (
+	"log/slog"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCanonicalSQL(t *testing.T) {
+
+	Convey("canonicalSQL", t, func() {
+
+		Convey("ignores keyword case and comma and operator spacing", func() {
+			So(canonicalSQL("[SELECT a,b FROM t WHERE x=1]"), ShouldEqual, canonicalSQL("[select a, b from t where x = 1]"))
+		})
+
+		Convey("sorts top-level AND predicates", func() {
+			So(canonicalSQL("[select * from t where b = 2 and a = 1]"), ShouldEqual, canonicalSQL("[select * from t where a = 1 and b = 2]"))
+			So(canonicalSQL("[select * from t where a = 1 and b = 2 order by a]"), ShouldEqual, "select * from t where a = 1 and b = 2 order by a")
+		})
+
+		Convey("keeps the AND of a BETWEEN inside its predicate", func() {
+			So(canonicalSQL("[select * from t where z = 1 and a between 1 and 5]"), ShouldEqual, "select * from t where a between 1 and 5 and z = 1")
+		})
+
+		Convey("sorts predicates past parentheses inside the clause", func() {
+			So(canonicalSQL("[select * from t where x in (1,2) and y = 1]"), ShouldEqual, canonicalSQL("[select * from t where y = 1 and x in (1, 2)]"))
+			So(canonicalSQL("[select * from t where (b = 2 or a = 1) and c = 3]"), ShouldEqual, canonicalSQL("[select * from t where c = 3 and (b = 2 or a = 1)]"))
+			So(canonicalSQL("[select * from t where x in (select a from u where c = 1) and b = 2 order by b]"), ShouldEqual,
+				canonicalSQL("[select * from t where b = 2 and x in (select a from u where c = 1) order by b]"))
+		})
+
+		Convey("keeps the ANDs of a CASE inside its predicate", func() {
+			So(canonicalSQL("[select * from t where case when a = 1 and b = 2 then 1 else 0 end = 1 and c = 3]"), ShouldEqual,
+				"select * from t where c = 3 and case when a = 1 and b = 2 then 1 else 0 end = 1")
+		})
+
+		Convey("leaves clauses with a top-level OR in order", func() {
+			So(canonicalSQL("[select * from t where b = 2 or a = 1]"), ShouldEqual, "select * from t where b = 2 or a = 1")
+		})
+
+		Convey("canonicalizes bind variables and <>", func() {
+			So(canonicalSQL("[select * from t where a = :a and b <> @b and c = ?]"), ShouldEqual, "select * from t where a = ? and b != ? and c = ?")
+		})
+
+		Convey("preserves string literals and quoted identifiers", func() {
+			So(canonicalSQL(`[select "Col" from t where a = 'Mixed  Case']`), ShouldEqual, `select "Col" from t where a = 'Mixed  Case'`)
+		})
+
+		Convey("renders qualified names without spaces", func() {
+			So(canonicalSQL("[select t . a from s.t]"), ShouldEqual, "select t.a from s.t")
+		})
+	})
+}
+
+func TestParseSQL(t *testing.T) {
+
+	Convey("parseSQL", t, func() {
+
+		Convey("finds tables in FROM lists and joins but not derived tables", func() {
+			stmt := parseSQL("select * from locmst l, aremst a join invlod i on i.stoloc = l.stoloc left join (select 1 from dual) d on 1 = 1 where l.wh_id = 'x'")
+			So(stmt.tables, ShouldResemble, []string{"locmst", "aremst", "invlod"})
+		})
+
+		Convey("finds selected columns by expression and alias", func() {
+			stmt := parseSQL("select distinct l.stoloc, count(*) n, max(a, b) from locmst l")
+			So(stmt.columns, ShouldResemble, [][]string{{"l.stoloc", "stoloc"}, {"count ( * ) n", "n"}, {"max ( a , b )"}})
+		})
+	})
+}
+
+func TestMatchQuery_SQL(t *testing.T) {
+
+	logger := slog.Default()
+
+	Convey("sql matching", t, func() {
+
+		entries, _ := NewInMemoryResponseLoader(
+			WithSQLMatch("select a, b from t where x = 1 and y = ?", NewResponse(StatusOK).WithMessage("statement").Build()),
+			WithSQLTableMatch("locmst", []string{"stoloc", "wh_id"}, NewResponse(StatusOK).WithMessage("table").Build()),
+			WithSQLTableMatch("", []string{"c"}, NewResponse(StatusOK).WithMessage("fallback").Build()),
+		).Load()
+		match := func(q string) string {
+			return matchQuery(normalizeLiteralQuery(q), entries, logger).Message
+		}
+
+		Convey("Given an equivalent statement written differently", func() {
+			Convey("Then the statement entry matches", func() {
+				So(match("[SELECT a,b FROM t WHERE y=:y AND x=1]"), ShouldEqual, "statement")
+				So(match("[select a, b from t where x = 1 and y = 'anything']"), ShouldEqual, "statement")
+			})
+		})
+
+		Convey("Given a statement with a different literal where the entry has one", func() {
+			Convey("Then the statement entry does not match", func() {
+				So(match("[select a, b, c from t where x = 2 and y = 1]"), ShouldEqual, "fallback")
+			})
+		})
+
+		Convey("Given a query on the table selecting the columns", func() {
+			Convey("Then the table entry matches regardless of column order or extra columns", func() {
+				So(match("[select l.wh_id, l.stoloc, l.arecod from locmst l where l.stoloc = 'A']"), ShouldEqual, "table")
+			})
+		})
+
+		Convey("Given a query on the table missing a column", func() {
+			Convey("Then the table entry does not match", func() {
+				So(match("[select stoloc from locmst]"), ShouldStartWith, "Command (")
+			})
+		})
+
+		Convey("Given a local syntax query", func() {
+			Convey("Then sql entries are not consulted", func() {
+				So(matchQuery(normalizeQuery("list locations"), entries, logger).StatusCode, ShouldEqual, StatusCommandNotFound)
+			})
+		})
+	})
+}
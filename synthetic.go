@@ -0,0 +1,284 @@
+package mocka
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyntheticSpec describes a generated result set. The same spec always
+// produces the same rows.
+type SyntheticSpec struct {
+	Seed    int64             `yaml:"seed"`
+	Rows    int               `yaml:"rows"`
+	Columns []SyntheticColumn `yaml:"columns"`
+	// Lazy defers generation until the first request that matches the
+	// entry. The generated result set is cached afterwards.
+	Lazy bool `yaml:"lazy,omitempty"`
+}
+
+// SyntheticColumn declares one generated column. Generator selects how
+// values are produced; the remaining fields configure it:
+//
+//   - sequence: Start, Start+Step, ... (defaults 1 and 1; a nil Start is
+//     unset, so a sequence may start at 0), optionally prefixed with Prefix.
+//     Type I, or S with a prefix.
+//   - enum: a random pick from Values. Type S.
+//   - pattern: Pattern with each # replaced by a random digit, each ? by a
+//     random uppercase letter and each * by a random letter or digit. Type S.
+//   - text: between Min and Max (default 1 to 5, or Min alone if it is
+//     larger) random lorem ipsum words. Type S.
+//   - number: a random number between Min and Max (default 0 to 100) with
+//     Decimals digits after the point. Type I, or F with decimals.
+//   - date: a random time between From and To (any layout accepted in
+//     results files; default the year 2024). Type D.
+//
+// NullRatio is the fraction of values, from 0 to 1, that are null. Type
+// overrides the generator's column type.
+type SyntheticColumn struct {
+	Name      string   `yaml:"name"`
+	Type      string   `yaml:"type,omitempty"`
+	Generator string   `yaml:"generator"`
+	NullRatio float64  `yaml:"null_ratio,omitempty"`
+	Start     *int64   `yaml:"start,omitempty"`
+	Step      int64    `yaml:"step,omitempty"`
+	Prefix    string   `yaml:"prefix,omitempty"`
+	Values    []string `yaml:"values,omitempty"`
+	Pattern   string   `yaml:"pattern,omitempty"`
+	Min       float64  `yaml:"min,omitempty"`
+	Max       float64  `yaml:"max,omitempty"`
+	Decimals  int      `yaml:"decimals,omitempty"`
+	From      string   `yaml:"from,omitempty"`
+	To        string   `yaml:"to,omitempty"`
+}
+
+var loremWords = strings.Fields(`lorem ipsum dolor sit amet consectetur adipiscing elit sed do
+	eiusmod tempor incididunt ut labore et dolore magna aliqua enim ad minim veniam quis nostrud
+	exercitation ullamco laboris nisi aliquip ex ea commodo consequat`)
+
+// columnGenerator produces the value of one column for successive rows.
+type columnGenerator func(r *rand.Rand, row int) any
+
+// GenerateResultSet returns the result set described by spec as a
+// <moca-results> XML fragment, ignoring spec.Lazy.
+func GenerateResultSet(spec SyntheticSpec) (string, error) {
+	rs, err := spec.builder()
+	if err != nil {
+		return "", err
+	}
+	results, err := rs.Validation(ValidationOff).Build()
+	if err != nil {
+		return "", err
+	}
+	return marshalResults(results)
+}
+
+// resultSet returns the XML for spec now, or a lazyResultSet that generates
+// it on first use if spec.Lazy is set. The spec is checked either way.
+func (spec SyntheticSpec) resultSet() (string, *lazyResultSet, error) {
+	if _, err := spec.generators(); err != nil {
+		return "", nil, err
+	}
+	if spec.Lazy {
+		return "", &lazyResultSet{generate: func() (string, error) { return GenerateResultSet(spec) }}, nil
+	}
+	xml, err := GenerateResultSet(spec)
+	return xml, nil, err
+}
+
+func (spec SyntheticSpec) builder() (*ResultSetBuilder, error) {
+	gens, err := spec.generators()
+	if err != nil {
+		return nil, err
+	}
+	rs := NewResultSet()
+	for i, c := range spec.Columns {
+		rs.Column(c.Name, gens[i].typ)
+	}
+	r := rand.New(rand.NewSource(spec.Seed))
+	values := make([]any, len(spec.Columns))
+	for row := range spec.Rows {
+		for i, c := range spec.Columns {
+			if c.NullRatio > 0 && r.Float64() < c.NullRatio {
+				values[i] = nil
+				continue
+			}
+			values[i] = gens[i].next(r, row)
+		}
+		rs.Row(values...)
+	}
+	return rs, nil
+}
+
+type typedGenerator struct {
+	typ  ColumnType
+	next columnGenerator
+}
+
+// generators validates spec and returns a generator per column.
+func (spec SyntheticSpec) generators() ([]typedGenerator, error) {
+	if spec.Rows < 0 {
+		return nil, fmt.Errorf("rows must not be negative")
+	}
+	gens := make([]typedGenerator, len(spec.Columns))
+	for i, c := range spec.Columns {
+		g, err := c.generator()
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", c.Name, err)
+		}
+		if c.Type != "" {
+			if g.typ, err = parseColumnType(c.Type); err != nil {
+				return nil, fmt.Errorf("column %s: %w", c.Name, err)
+			}
+		}
+		if c.NullRatio < 0 || c.NullRatio > 1 {
+			return nil, fmt.Errorf("column %s: null_ratio must be between 0 and 1", c.Name)
+		}
+		gens[i] = g
+	}
+	return gens, nil
+}
+
+func (c SyntheticColumn) generator() (typedGenerator, error) {
+	switch c.Generator {
+	case "sequence":
+		start, step := int64(1), c.Step
+		if c.Start != nil {
+			start = *c.Start
+		}
+		if step == 0 {
+			step = 1
+		}
+		if c.Prefix != "" {
+			return typedGenerator{TypeString, func(_ *rand.Rand, row int) any {
+				return c.Prefix + strconv.FormatInt(start+int64(row)*step, 10)
+			}}, nil
+		}
+		return typedGenerator{TypeInteger, func(_ *rand.Rand, row int) any {
+			return start + int64(row)*step
+		}}, nil
+	case "enum":
+		if len(c.Values) == 0 {
+			return typedGenerator{}, fmt.Errorf("enum requires values")
+		}
+		return typedGenerator{TypeString, func(r *rand.Rand, _ int) any {
+			return c.Values[r.Intn(len(c.Values))]
+		}}, nil
+	case "pattern":
+		if c.Pattern == "" {
+			return typedGenerator{}, fmt.Errorf("pattern requires a pattern")
+		}
+		return typedGenerator{TypeString, func(r *rand.Rand, _ int) any {
+			return expandPattern(r, c.Pattern)
+		}}, nil
+	case "text":
+		lo, hi := int(c.Min), int(c.Max)
+		if hi == 0 {
+			if lo == 0 {
+				lo = 1
+			}
+			hi = max(lo, 5)
+		}
+		if lo < 1 || hi < lo {
+			return typedGenerator{}, fmt.Errorf("text requires 1 <= min <= max")
+		}
+		return typedGenerator{TypeString, func(r *rand.Rand, _ int) any {
+			words := make([]string, lo+r.Intn(hi-lo+1))
+			for i := range words {
+				words[i] = loremWords[r.Intn(len(loremWords))]
+			}
+			return strings.Join(words, " ")
+		}}, nil
+	case "number":
+		lo, hi := c.Min, c.Max
+		if lo == 0 && hi == 0 {
+			hi = 100
+		}
+		if hi < lo {
+			return typedGenerator{}, fmt.Errorf("number requires min <= max")
+		}
+		if c.Decimals > 0 {
+			scale := math.Pow10(c.Decimals)
+			return typedGenerator{TypeFloat, func(r *rand.Rand, _ int) any {
+				return math.Round((lo+r.Float64()*(hi-lo))*scale) / scale
+			}}, nil
+		}
+		return typedGenerator{TypeInteger, func(r *rand.Rand, _ int) any {
+			return int64(lo) + r.Int63n(int64(hi)-int64(lo)+1)
+		}}, nil
+	case "date":
+		from, to := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		var err error
+		if c.From != "" {
+			if from, err = parseDate(c.From); err != nil {
+				return typedGenerator{}, err
+			}
+		}
+		if c.To != "" {
+			if to, err = parseDate(c.To); err != nil {
+				return typedGenerator{}, err
+			}
+		}
+		span := to.Unix() - from.Unix()
+		if span <= 0 {
+			return typedGenerator{}, fmt.Errorf("date requires from at least one second before to")
+		}
+		return typedGenerator{TypeDate, func(r *rand.Rand, _ int) any {
+			return from.Add(time.Duration(r.Int63n(span)) * time.Second)
+		}}, nil
+	}
+	return typedGenerator{}, fmt.Errorf("unknown generator %q", c.Generator)
+}
+
+// expandPattern replaces # with a digit, ? with an uppercase letter and *
+// with a letter or digit.
+func expandPattern(r *rand.Rand, pattern string) string {
+	const (
+		digits  = "0123456789"
+		letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	)
+	var b strings.Builder
+	for _, ch := range pattern {
+		switch ch {
+		case '#':
+			b.WriteByte(digits[r.Intn(len(digits))])
+		case '?':
+			b.WriteByte(letters[r.Intn(len(letters))])
+		case '*':
+			all := letters + digits
+			b.WriteByte(all[r.Intn(len(all))])
+		default:
+			b.WriteRune(ch)
+		}
+	}
+	return b.String()
+}
+
+// parseDate parses s using any of the layouts accepted in results files.
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("cannot parse date %q", s)
+}
+
+// lazyResultSet generates a result set on first use and caches it.
+type lazyResultSet struct {
+	once     sync.Once
+	generate func() (string, error)
+	xml      string
+	err      error
+}
+
+func (l *lazyResultSet) get() (string, error) {
+	l.once.Do(func() {
+		l.xml, l.err = l.generate()
+	})
+	return l.xml, l.err
+}
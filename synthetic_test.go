@@ -0,0 +1,163 @@
+package mocka
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/castingcode/mocaprotocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func testSyntheticSpec() SyntheticSpec {
+	return SyntheticSpec{
+		Seed: 42,
+		Rows: 50,
+		Columns: []SyntheticColumn{
+			{Name: "id", Generator: "sequence", Start: new(int64(100)), Step: 10},
+			{Name: "lodnum", Generator: "sequence", Prefix: "LOD"},
+			{Name: "wh_id", Generator: "enum", Values: []string{"MHE", "WMD"}},
+			{Name: "prtnum", Generator: "pattern", Pattern: "PRT-##??"},
+			{Name: "lngdsc", Generator: "text", Min: 2, Max: 3, NullRatio: 0.5},
+			{Name: "qty", Generator: "number", Min: 1, Max: 9},
+			{Name: "weight", Generator: "number", Max: 10, Decimals: 2},
+			{Name: "adddte", Generator: "date", From: "2024-01-01", To: "2024-02-01"},
+		},
+	}
+}
+
+func TestGenerateResultSet(t *testing.T) {
+
+	Convey("GenerateResultSet", t, func() {
+
+		Convey("produces the declared columns and rows", func() {
+			out, err := GenerateResultSet(testSyntheticSpec())
+			So(err, ShouldBeNil)
+			var results mocaprotocol.MocaResults
+			So(xml.Unmarshal([]byte(out), &results), ShouldBeNil)
+
+			cols := results.Metadata.Columns
+			So(cols, ShouldHaveLength, 8)
+			So(cols[0].Type, ShouldEqual, "I")
+			So(cols[1].Type, ShouldEqual, "S")
+			So(cols[5].Type, ShouldEqual, "I")
+			So(cols[6].Type, ShouldEqual, "F")
+			So(cols[7].Type, ShouldEqual, "D")
+			So(results.Data.Rows, ShouldHaveLength, 50)
+			So(ValidateResults(results), ShouldBeNil)
+
+			first := results.Data.Rows[0].Fields
+			So(first[0].Value, ShouldEqual, "100")
+			So(results.Data.Rows[2].Fields[0].Value, ShouldEqual, "120")
+			So(first[1].Value, ShouldEqual, "LOD1")
+			So(first[2].Value, ShouldBeIn, "MHE", "WMD")
+			So(first[3].Value, ShouldHaveLength, len("PRT-##??"))
+			So(first[7].Value, ShouldStartWith, "202401")
+		})
+
+		Convey("starts a sequence at 0 when Start is set to 0", func() {
+			out, err := GenerateResultSet(SyntheticSpec{Rows: 2, Columns: []SyntheticColumn{
+				{Name: "n", Generator: "sequence", Start: new(int64(0))},
+				{Name: "d", Generator: "sequence"},
+			}})
+			So(err, ShouldBeNil)
+			var results mocaprotocol.MocaResults
+			So(xml.Unmarshal([]byte(out), &results), ShouldBeNil)
+			So(results.Data.Rows[0].Fields[0].Value, ShouldEqual, "0")
+			So(results.Data.Rows[0].Fields[1].Value, ShouldEqual, "1")
+		})
+
+		Convey("keeps a text Min set without a Max", func() {
+			out, err := GenerateResultSet(SyntheticSpec{Rows: 20, Columns: []SyntheticColumn{
+				{Name: "lngdsc", Generator: "text", Min: 8},
+			}})
+			So(err, ShouldBeNil)
+			var results mocaprotocol.MocaResults
+			So(xml.Unmarshal([]byte(out), &results), ShouldBeNil)
+			for _, row := range results.Data.Rows {
+				So(strings.Fields(row.Fields[0].Value), ShouldHaveLength, 8)
+			}
+		})
+
+		Convey("is deterministic for a seed", func() {
+			a, err := GenerateResultSet(testSyntheticSpec())
+			So(err, ShouldBeNil)
+			b, _ := GenerateResultSet(testSyntheticSpec())
+			So(a, ShouldEqual, b)
+
+			spec := testSyntheticSpec()
+			spec.Seed = 7
+			c, _ := GenerateResultSet(spec)
+			So(c, ShouldNotEqual, a)
+		})
+
+		Convey("honors null_ratio", func() {
+			out, _ := GenerateResultSet(testSyntheticSpec())
+			var results mocaprotocol.MocaResults
+			So(xml.Unmarshal([]byte(out), &results), ShouldBeNil)
+			nulls := 0
+			for _, row := range results.Data.Rows {
+				if row.Fields[4].Null == "true" {
+					nulls++
+				}
+			}
+			So(nulls, ShouldBeBetween, 0, 50)
+		})
+
+		Convey("rejects invalid specs", func() {
+			for _, col := range []SyntheticColumn{
+				{Name: "x", Generator: "bogus"},
+				{Name: "x", Generator: "enum"},
+				{Name: "x", Generator: "pattern"},
+				{Name: "x", Generator: "number", Min: 5, Max: 1},
+				{Name: "x", Generator: "date", From: "2024-02-01", To: "2024-01-01"},
+				{Name: "x", Generator: "date", From: "2024-01-01T00:00:00Z", To: "2024-01-01T00:00:00.5Z"},
+				{Name: "x", Generator: "sequence", NullRatio: 2},
+				{Name: "x", Generator: "sequence", Type: "Q"},
+			} {
+				_, err := GenerateResultSet(SyntheticSpec{Rows: 1, Columns: []SyntheticColumn{col}})
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldStartWith, "column x:")
+			}
+		})
+	})
+}
+
+func TestResponseBuilder_WithSyntheticResultSet(t *testing.T) {
+
+	Convey("WithSyntheticResultSet", t, func() {
+
+		Convey("generates eagerly by default", func() {
+			b, err := NewResponse(StatusOK).WithSyntheticResultSet(testSyntheticSpec())
+			So(err, ShouldBeNil)
+			So(b.Build().ResultSet, ShouldContainSubstring, "<moca-results>")
+		})
+
+		Convey("defers generation to first use when lazy", func() {
+			spec := testSyntheticSpec()
+			spec.Lazy = true
+			b, err := NewResponse(StatusOK).WithSyntheticResultSet(spec)
+			So(err, ShouldBeNil)
+			resp := b.Build()
+			So(resp.ResultSet, ShouldBeEmpty)
+
+			out, err := resp.resultSet()
+			So(err, ShouldBeNil)
+			eager, _ := GenerateResultSet(testSyntheticSpec())
+			So(out, ShouldEqual, eager)
+		})
+
+		Convey("rejects an invalid spec even when lazy", func() {
+			_, err := NewResponse(StatusOK).WithSyntheticResultSet(SyntheticSpec{
+				Lazy:    true,
+				Columns: []SyntheticColumn{{Name: "x", Generator: "bogus"}},
+			})
+			So(err, ShouldNotBeNil)
+			_, err = NewResponse(StatusOK).WithSyntheticResultSet(SyntheticSpec{
+				Lazy:    true,
+				Columns: []SyntheticColumn{{Name: "x", Generator: "date", From: "2024-01-01T00:00:00Z", To: "2024-01-01T00:00:00.5Z"}},
+			})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,70 @@
+package mocka
+
+import (
+	"maps"
+	"strings"
+
+	"github.com/castingcode/mocaprotocol"
+)
+
+// environmentVars returns the request's environment variables keyed by
+// lowercased name, the way @variables refer to them.
+func environmentVars(request mocaprotocol.MocaRequest) map[string]string {
+	env := make(map[string]string, len(request.Environment.Vars))
+	for _, v := range request.Environment.Vars {
+		env[strings.ToLower(v.Name)] = v.Value
+	}
+	return env
+}
+
+// bindQueryVariables resolves the @variables of a command or embedded SQL
+// statement, bare or inside publish data layers, for example
+//
+//	publish data where wh_id = 'MHE' | list inventory where wh_id = @wh_id
+//
+// becomes "list inventory where wh_id = 'MHE'". Variables are looked up in
+// the publish data context first and then in env; @* expands to the context
+// only. query must be normalized, and the result is normalized the same way.
+// ok is false if query has no variables that could be resolved.
+func bindQueryVariables(query string, env map[string]string) (bound string, ok bool) {
+	node, err := parseQuery(query)
+	if err != nil {
+		return "", false
+	}
+	ctx, inner, _ := flattenPublishData(node)
+	vars := maps.Clone(env)
+	if vars == nil {
+		vars = make(map[string]string)
+	}
+	maps.Copy(vars, ctx)
+	switch n := inner.(type) {
+	case *commandNode:
+		if !n.bindsVariables(vars, ctx) {
+			return "", false
+		}
+		bound = n.render(vars, ctx)
+	case *sqlNode:
+		bound = "[" + bindSQLVariables(n.text, vars) + "]"
+		if bound == n.source(query) {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	return normalizeLiteralQuery(bound), true
+}
+
+// bindsVariables reports whether bindArgs(vars, star) would resolve any of
+// cmd's @variables.
+func (cmd *commandNode) bindsVariables(vars, star map[string]string) bool {
+	for _, a := range cmd.args {
+		if a.value.kind != valueVariable {
+			continue
+		}
+		name := strings.TrimLeft(a.value.text, "+-%")
+		if _, ok := vars[name]; ok || name == "*" && len(star) > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package mocka
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/castingcode/mocaprotocol"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func withEnvironmentVar(name, value string) TestRequestOption {
+	return func(r *mocaprotocol.MocaRequest) {
+		r.Environment.Vars = append(r.Environment.Vars, mocaprotocol.Var{Name: name, Value: value})
+	}
+}
+
+func TestBindQueryVariables(t *testing.T) {
+
+	Convey("bindQueryVariables", t, func() {
+		bind := func(q string, env map[string]string) (string, bool) {
+			return bindQueryVariables(normalizeLiteralQuery(q), env)
+		}
+
+		Convey("binds variables from the publish data context", func() {
+			bound, ok := bind("publish data where wh_id = 'MHE' | list inventory where wh_id = @wh_id", nil)
+			So(ok, ShouldBeTrue)
+			So(bound, ShouldEqual, "list inventory where wh_id = 'MHE'")
+		})
+
+		Convey("binds through nested layers and blocks", func() {
+			bound, ok := bind("publish data where a = 1 | { publish data where b = 2 | { list x where a = @a and b = @b } }", nil)
+			So(ok, ShouldBeTrue)
+			So(bound, ShouldEqual, "list x where a = '1' and b = '2'")
+		})
+
+		Convey("expands @* and @+name from the context", func() {
+			bound, _ := bind("publish data where b = 2 and a = 1 | list x where @*", nil)
+			So(bound, ShouldEqual, "list x where a = '1' and b = '2'")
+			bound, _ = bind("publish data where a = 1 | list x where @+a", nil)
+			So(bound, ShouldEqual, "list x where a = '1'")
+		})
+
+		Convey("falls back to the environment, with the context winning", func() {
+			bound, _ := bind("list inventory where wh_id = @wh_id and usr_id = @usr_id", map[string]string{"wh_id": "ENV", "usr_id": "SUPER"})
+			So(bound, ShouldEqual, "list inventory where wh_id = 'ENV' and usr_id = 'SUPER'")
+			bound, _ = bind("publish data where wh_id = 'MHE' | list inventory where wh_id = @wh_id", map[string]string{"wh_id": "ENV"})
+			So(bound, ShouldEqual, "list inventory where wh_id = 'MHE'")
+		})
+
+		Convey("does not expand @* from the environment", func() {
+			_, ok := bind("list x where @*", map[string]string{"wh_id": "ENV"})
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("binds variables in embedded SQL", func() {
+			bound, ok := bind("publish data where wh_id = 'MHE' | [select * from locmst where wh_id = @wh_id]", nil)
+			So(ok, ShouldBeTrue)
+			So(bound, ShouldEqual, "[select * from locmst where wh_id = 'MHE']")
+		})
+
+		Convey("reports false when nothing can be bound", func() {
+			_, ok := bind("publish data where a = 1 | list x where b = @b", nil)
+			So(ok, ShouldBeFalse)
+			_, ok = bind("list x where b = 2", map[string]string{"b": "3"})
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func TestHandleMocaRequest_VariableBinding(t *testing.T) {
+
+	Convey("Given an entry for a command with a literal argument", t, func() {
+
+		lookup, err := NewResponseLookup(NewInMemoryResponseLoader(
+			WithExactMatch("list inventory where wh_id = 'MHE'", NewResponse(StatusOK).WithMessage("bound").Build()),
+			WithPublishDataMatch("list areas where wh_id = @wh_id", NewResponse(StatusOK).WithMessage("unbound").Build()),
+		))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mux := http.NewServeMux()
+		RegisterRoutes(mux, NewMocaRequestHandler(lookup, WithSessionMode(SessionModeDisabled)))
+
+		Convey("Then a query passing the value by publish data matches it", func() {
+			response := sendRequest(t, mux, "publish data where wh_id = 'MHE' | list inventory where wh_id = @wh_id")
+			So(response.Message, ShouldEqual, "bound")
+		})
+
+		Convey("Then a query taking the value from the environment matches it", func() {
+			response := sendRequest(t, mux, "list inventory where wh_id = @wh_id", withEnvironmentVar("WH_ID", "MHE"))
+			So(response.Message, ShouldEqual, "bound")
+		})
+
+		Convey("Then entries registered with the variable still match first", func() {
+			response := sendRequest(t, mux, "publish data where wh_id = 'MHE' | { list areas where wh_id = @wh_id }")
+			So(response.Message, ShouldEqual, "unbound")
+		})
+
+		Convey("Then a value with no entry is still not found", func() {
+			response := sendRequest(t, mux, "publish data where wh_id = 'WMD' | list inventory where wh_id = @wh_id")
+			So(response.Status, ShouldEqual, StatusCommandNotFound)
+		})
+	})
+}